@@ -0,0 +1,321 @@
+// Package bench implements a load generator for the ringoexp ingest websocket. It opens many
+// connections, sends values at a target rate or as fast as the server acks them, and measures
+// throughput and ack latency. A connection answered busy pauses sending for the retry-after,
+// then goes back and sends the busy frame and those after it again.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/composer22/ringoexp/protocol"
+	"golang.org/x/net/websocket"
+)
+
+// drainTimeout is how long a connection waits on replies to the frames in flight once it has
+// finished sending.
+const drainTimeout = 5 * time.Second
+
+// Options are the parameters of a load test.
+type Options struct {
+	URL         string        // The ingest endpoint, such as ws://localhost:6660/v1.0/ingest.
+	Connections int           // Websocket connections opened in parallel.
+	Batch       int           // Values per frame. 1 sends Data frames, more sends Batch frames.
+	Window      int           // Frames each connection may send before waiting on an ack.
+	Rate        int           // Target values per second across all connections, 0 = no limit.
+	Count       int           // Total values to send, 0 = until Duration passes.
+	Duration    time.Duration // How long to send for if Count is 0.
+}
+
+// Result is the summary of a load test.
+type Result struct {
+	Connections int             // Connections that were opened.
+	Sent        int64           // Values sent, counting those sent again after a busy reply.
+	Frames      int64           // Frames sent.
+	Acked       int64           // Values acked.
+	Errors      int64           // Values answered with an Error frame.
+	Busy        int64           // Values answered with a Busy frame.
+	Elapsed     time.Duration   // Time from the first send to the last reply.
+	latencies   []time.Duration // Ack latency of every acked frame, sorted.
+}
+
+// inflight is a frame sent and waiting on a reply.
+type inflight struct {
+	first uint64    // Sequence of the first value in the frame.
+	last  uint64    // Sequence of the last value in the frame.
+	sent  time.Time // When the frame was sent.
+	gen   int       // The rewind the frame was sent after.
+}
+
+// conn is the state of one connection in a load test.
+type conn struct {
+	opts      *Options
+	ws        *websocket.Conn
+	values    int64           // Values this connection should send, 0 = until stopped.
+	interval  time.Duration   // Time between frames to meet the rate, 0 = no limit.
+	window    chan bool       // Holds a token per frame in flight.
+	mu        sync.Mutex      // For locking access to pending, resume, rewind and gen.
+	pending   []inflight      // Frames in flight, oldest first.
+	resume    time.Time       // When sending may resume after a Busy frame.
+	rewind    uint64          // Sequence to go back to after a Busy frame, 0 = none.
+	gen       int             // Rewinds made, so busy replies to frames already sent again are ignored.
+	sent      int64           // Values sent.
+	frames    int64           // Frames sent.
+	acked     int64           // Values acked.
+	errors    int64           // Values answered with an Error frame.
+	busy      int64           // Values answered with a Busy frame.
+	latencies []time.Duration // Ack latency of every acked frame.
+	err       error           // Why the connection stopped early, if it did.
+}
+
+// Run opens the connections and sends values until Count values are sent or Duration passes,
+// or ctx is cancelled. It then waits for outstanding replies and returns the summary.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	if opts.Connections < 1 || opts.Batch < 1 || opts.Window < 1 {
+		return nil, errors.New("bench: connections, batch and window must be 1 or more")
+	}
+	if opts.Count == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	// A connection with no share of the count would send until stopped, so open no more than
+	// there are values to send.
+	n := opts.Connections
+	if opts.Count > 0 {
+		n = min(n, opts.Count)
+	}
+	origin := strings.Replace(strings.Replace(opts.URL, "wss://", "https://", 1), "ws://", "http://", 1)
+	conns := make([]*conn, n)
+	for i := range conns {
+		ws, err := websocket.Dial(opts.URL, "", origin)
+		if err != nil {
+			for _, c := range conns[:i] {
+				c.ws.Close()
+			}
+			return nil, err
+		}
+		c := &conn{
+			opts:   &opts,
+			ws:     ws,
+			window: make(chan bool, opts.Window),
+		}
+		if opts.Count > 0 {
+			c.values = int64(opts.Count / n)
+			if i == 0 {
+				c.values += int64(opts.Count % n)
+			}
+		}
+		if opts.Rate > 0 {
+			c.interval = time.Duration(float64(time.Second) * float64(opts.Batch*n) /
+				float64(opts.Rate))
+		}
+		conns[i] = c
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(2)
+		go c.receive(&wg)
+		go c.send(ctx, &wg)
+	}
+	wg.Wait()
+
+	r := &Result{Connections: len(conns), Elapsed: time.Since(start)}
+	var err error
+	for _, c := range conns {
+		r.Sent += c.sent
+		r.Frames += c.frames
+		r.Acked += c.acked
+		r.Errors += c.errors
+		r.Busy += c.busy
+		r.latencies = append(r.latencies, c.latencies...)
+		if c.err != nil && err == nil {
+			err = c.err
+		}
+	}
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	return r, err
+}
+
+// send writes frames until the connection's share is sent or ctx is done, then waits for the
+// replies to drain and closes the socket.
+func (c *conn) send(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer c.ws.Close()
+	seq := uint64(1)
+	next := time.Now()
+	values := make([]int32, c.opts.Batch)
+	for {
+		seq = c.rewound(seq)
+		if c.values > 0 && seq > uint64(c.values) {
+			// Everything is sent, but a busy reply still to come may ask for some of it again.
+			c.drain()
+			if seq = c.rewound(seq); seq > uint64(c.values) {
+				return
+			}
+		}
+		if c.interval > 0 {
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+			}
+			next = next.Add(c.interval)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			c.drain()
+			return
+		case c.window <- true: // Wait on room in the window.
+		}
+		c.mu.Lock()
+		busy := time.Until(c.resume)
+		c.mu.Unlock()
+		if busy > 0 { // The server asked us to slow down.
+			select {
+			case <-ctx.Done():
+			case <-time.After(busy):
+			}
+		}
+		seq = c.rewound(seq) // In case a frame was answered busy while waiting.
+
+		n := len(values)
+		if c.values > 0 && uint64(c.values)-seq+1 < uint64(n) {
+			n = int(uint64(c.values) - seq + 1)
+		}
+		for i := range values[:n] {
+			values[i] = int32(seq) + int32(i)
+		}
+		f := protocol.DataFrameNew(seq, values[0])
+		if n > 1 {
+			f = protocol.BatchFrameNew(seq, values[:n])
+		}
+
+		c.mu.Lock()
+		c.pending = append(c.pending, inflight{first: seq, last: f.LastSeq(), sent: time.Now(), gen: c.gen})
+		c.mu.Unlock()
+		if err := websocket.Message.Send(c.ws, protocol.Encode(f)); err != nil {
+			c.err = err
+			return
+		}
+		c.sent += int64(n)
+		c.frames++
+		seq += uint64(n)
+	}
+	c.drain()
+}
+
+// drain waits for every frame in flight to be answered, giving up after drainTimeout. The
+// window is left as it was found, so sending may carry on.
+func (c *conn) drain() {
+	timeout := time.After(drainTimeout)
+	held := 0
+	defer func() {
+		for ; held > 0; held-- {
+			<-c.window
+		}
+	}()
+	for held < cap(c.window) {
+		select {
+		case c.window <- true:
+			held++
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// rewound returns the sequence to send next: seq, or the first frame answered busy, which must
+// be sent again along with every frame after it.
+func (c *conn) rewound(seq uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rewind != 0 {
+		seq, c.rewind = c.rewind, 0
+		c.gen++
+	}
+	return seq
+}
+
+// receive reads replies, matching them to the frames in flight, until the socket closes.
+func (c *conn) receive(wg *sync.WaitGroup) {
+	defer wg.Done()
+	var resp []byte
+	for websocket.Message.Receive(c.ws, &resp) == nil {
+		f, err := protocol.Decode(resp)
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		c.mu.Lock()
+		for len(c.pending) > 0 {
+			p := c.pending[0]
+			n := int64(p.last - p.first + 1)
+			if f.Type == protocol.TypeAck && p.last <= f.Seq { // Acks are cumulative.
+				c.acked += n
+				c.latencies = append(c.latencies, now.Sub(p.sent))
+			} else if f.Type == protocol.TypeError && p.first == f.Seq {
+				c.errors += n
+			} else if f.Type == protocol.TypeBusy && p.first == f.Seq {
+				c.busy += n
+				if p.gen == c.gen && c.rewind == 0 { // Go back to the first frame refused.
+					c.rewind = p.first
+				}
+				retry, _ := f.RetryAfter()
+				c.resume = now.Add(retry)
+			} else {
+				break // Not answered yet.
+			}
+			c.pending = c.pending[1:]
+			<-c.window
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Percentile returns the ack latency below which p percent of acked frames were answered. Frames
+// answered busy or with an error are counted in Busy and Errors, not here.
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(r.latencies)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return r.latencies[i]
+}
+
+// Throughput returns the values acked per second.
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Acked) / r.Elapsed.Seconds()
+}
+
+// String is an implentation of the Stringer interface so the summary is returned as a string
+// to fmt.Print() etc.
+func (r *Result) String() string {
+	return fmt.Sprintf("Connections: %d\n"+
+		"Sent:        %d values in %d frames over %s\n"+
+		"Acked:       %d values (%d errors, %d busy)\n"+
+		"Throughput:  %.0f values/s\n"+
+		"Ack latency: p50 %s  p90 %s  p99 %s  p99.9 %s  max %s\n",
+		r.Connections, r.Sent, r.Frames, r.Elapsed.Round(time.Millisecond), r.Acked, r.Errors,
+		r.Busy, r.Throughput(), r.Percentile(50), r.Percentile(90), r.Percentile(99),
+		r.Percentile(99.9), r.Percentile(100))
+}
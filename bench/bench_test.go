@@ -0,0 +1,172 @@
+package bench
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/composer22/ringoexp/protocol"
+	"golang.org/x/net/websocket"
+)
+
+// testBusyRetry is the retry-after of the Busy frames sent by testServer.
+const testBusyRetry = 100 * time.Millisecond
+
+// testServer returns an ingest server that acks every frame, or answers frames holding the
+// value reject with an Error frame and the first holding the value busy with a Busy frame. Like
+// the publisher, it refuses the frames after a busy one until it is sent again.
+func testServer(reject, busy int32) *httptest.Server {
+	return httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var req []byte
+		var held uint64 // Sequence of the busy frame until it is sent again, 0 = none.
+		wasBusy := false
+		for websocket.Message.Receive(ws, &req) == nil {
+			f, err := protocol.Decode(req)
+			if err != nil {
+				return
+			}
+			reply := protocol.AckFrameNew(f.LastSeq())
+			values, _ := f.Values()
+			for _, v := range values {
+				switch {
+				case v == reject:
+					reply = protocol.ErrorFrameNew(f.Seq, protocol.CodeInternal, "rejected")
+				case v == busy && !wasBusy:
+					reply = protocol.BusyFrameNew(f.Seq, testBusyRetry)
+					held, wasBusy = f.Seq, true
+				}
+			}
+			switch {
+			case held != 0 && f.Seq > held:
+				reply = protocol.BusyFrameNew(f.Seq, testBusyRetry)
+			case held != 0 && f.Seq == held && reply.Type == protocol.TypeAck:
+				held = 0
+			}
+			websocket.Message.Send(ws, protocol.Encode(reply))
+		}
+	}))
+}
+
+func testURL(s *httptest.Server) string {
+	return strings.Replace(s.URL, "http://", "ws://", 1)
+}
+
+func TestRunCount(t *testing.T) {
+	t.Parallel()
+	s := testServer(-1, -1)
+	defer s.Close()
+	tests := []struct {
+		connections, batch, count int
+		frames                    int64
+	}{
+		{1, 1, 10, 10},
+		{3, 4, 25, 7}, // Shares of 9, 8 and 8: frames of 4, 4, 1 then 4, 4 twice.
+		{4, 1, 2, 2},  // Fewer values than connections: only 2 connections are opened.
+	}
+	for _, tc := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second) // Don't hang on a bad share.
+		r, err := Run(ctx, Options{URL: testURL(s), Connections: tc.connections,
+			Batch: tc.batch, Window: 2, Count: tc.count})
+		cancel()
+		if err != nil {
+			t.Fatalf("Run returned an error: %s", err.Error())
+		}
+		if r.Sent != int64(tc.count) || r.Acked != int64(tc.count) || r.Frames != tc.frames || r.Errors != 0 ||
+			r.Connections != min(tc.connections, tc.count) {
+			t.Errorf("Run %+v incorrect.\n\nActual:\n%s", tc, r)
+		}
+		if len(r.latencies) != int(tc.frames) || r.Percentile(50) <= 0 || r.Percentile(100) < r.Percentile(50) {
+			t.Errorf("Run %+v latencies incorrect: %v", tc, r.latencies)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	t.Parallel()
+	s := testServer(3, -1) // The third value of each connection is rejected.
+	defer s.Close()
+	r, err := Run(context.Background(), Options{URL: testURL(s), Connections: 2, Batch: 1,
+		Window: 1, Count: 10})
+	if err != nil {
+		t.Fatalf("Run returned an error: %s", err.Error())
+	}
+	if r.Acked != 8 || r.Errors != 2 {
+		t.Errorf("Run should count errors.\n\nActual:\n%s", r)
+	}
+}
+
+func TestRunBusy(t *testing.T) {
+	t.Parallel()
+	s := testServer(-1, 2) // The second value is answered busy.
+	defer s.Close()
+	r, err := Run(context.Background(), Options{URL: testURL(s), Connections: 1, Batch: 1,
+		Window: 1, Count: 5})
+	if err != nil {
+		t.Fatalf("Run returned an error: %s", err.Error())
+	}
+	if r.Acked != 5 || r.Busy != 1 || r.Errors != 0 || r.Sent != 6 {
+		t.Errorf("Run should count busy replies and send the busy value again.\n\nActual:\n%s", r)
+	}
+	if len(r.latencies) != 5 {
+		t.Errorf("Only acked frames should be in the ack latency, found %d.", len(r.latencies))
+	}
+	if r.Elapsed < testBusyRetry {
+		t.Errorf("Run should pause for the retry-after of a busy reply, took %s.", r.Elapsed)
+	}
+
+	// Frames pipelined after the busy one are refused, so they are sent again too.
+	s = testServer(-1, 2)
+	defer s.Close()
+	r, err = Run(context.Background(), Options{URL: testURL(s), Connections: 1, Batch: 1,
+		Window: 4, Count: 5})
+	if err != nil {
+		t.Fatalf("Run returned an error: %s", err.Error())
+	}
+	if r.Acked != 5 || r.Errors != 0 || r.Sent != 5+r.Busy {
+		t.Errorf("Run should go back to the busy frame and send those after it again.\n\nActual:\n%s", r)
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	t.Parallel()
+	s := testServer(-1, -1)
+	defer s.Close()
+	r, err := Run(context.Background(), Options{URL: testURL(s), Connections: 2, Batch: 1,
+		Window: 4, Rate: 100, Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Run returned an error: %s", err.Error())
+	}
+	// 100 values/s for 200ms is about 20, allowing for timer slop.
+	if r.Sent < 10 || r.Sent > 30 || r.Acked != r.Sent {
+		t.Errorf("Run should pace to the rate.\n\nActual:\n%s", r)
+	}
+}
+
+func TestRunOptions(t *testing.T) {
+	t.Parallel()
+	if _, err := Run(context.Background(), Options{URL: "ws://localhost:1/", Connections: 1}); err == nil {
+		t.Errorf("Run should reject a batch or window of 0.")
+	}
+	if _, err := Run(context.Background(), Options{URL: "ws://localhost:1/", Connections: 1,
+		Batch: 1, Window: 1, Count: 1}); err == nil {
+		t.Errorf("Run should fail when the server cannot be reached.")
+	}
+}
+
+func TestResultPercentile(t *testing.T) {
+	t.Parallel()
+	r := &Result{}
+	if r.Percentile(99) != 0 || r.Throughput() != 0 {
+		t.Errorf("An empty result should report zero.")
+	}
+	for i := 1; i <= 100; i++ {
+		r.latencies = append(r.latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[float64]time.Duration{0: 1, 50: 50, 99: 99, 99.9: 100, 100: 100} {
+		if actual := r.Percentile(p); actual != expected*time.Millisecond {
+			t.Errorf("Percentile %v incorrect.\n\nExpected: %s\n\nActual: %s\n", p, expected*time.Millisecond, actual)
+		}
+	}
+}
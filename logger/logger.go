@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
 // Standard labels.
@@ -60,7 +61,7 @@ type exiter func(code int)
 // Logger provides a datastructure for all logging state.
 type Logger struct {
 	logger *log.Logger
-	level  int
+	level  int32 // Read and written atomically as a reload may change it while others log.
 	labels []string
 	exit   exiter
 }
@@ -75,7 +76,7 @@ func New(lvl int, clrs bool) *Logger {
 
 	l := &Logger{
 		logger: log.New(os.Stdout, pre, flags),
-		level:  lvl,
+		level:  int32(lvl),
 		exit:   func(code int) { os.Exit(code) },
 	}
 
@@ -96,7 +97,7 @@ func (l *Logger) SetLogLevel(lvl int) error {
 	if lvl == UseDefault {
 		lvl = Info
 	}
-	l.level = lvl
+	atomic.StoreInt32(&l.level, int32(lvl))
 	return nil
 }
 
@@ -111,7 +112,7 @@ func (l *Logger) SetExitFunc(e exiter) error {
 
 // GetLogLevel returns the current log level of the logger.
 func (l *Logger) GetLogLevel() int {
-	return l.level
+	return int(atomic.LoadInt32(&l.level))
 }
 
 // SetPlainLabels sets the message labels to simple text output.
@@ -143,7 +144,7 @@ func (l *Logger) SetColouredLabels() {
 // Emergencyf prints an emergency message to the system log,
 // This is considered an unrecoverable error and the application also exits, unless dont exit = true.
 func (l *Logger) Emergencyf(format string, v ...interface{}) {
-	if l.level >= Emergency {
+	if l.GetLogLevel() >= Emergency {
 		l.Output(3, Labels[Emergency], format, v...)
 	}
 	l.performExit(l.exit)
@@ -151,49 +152,49 @@ func (l *Logger) Emergencyf(format string, v ...interface{}) {
 
 // Alertf prints an alert message to the system log.
 func (l *Logger) Alertf(format string, v ...interface{}) {
-	if l.level >= Alert {
+	if l.GetLogLevel() >= Alert {
 		l.Output(3, Labels[Alert], format, v...)
 	}
 }
 
 // Criticalf prints a critical message to the system log.
 func (l *Logger) Criticalf(format string, v ...interface{}) {
-	if l.level >= Critical {
+	if l.GetLogLevel() >= Critical {
 		l.Output(3, Labels[Critical], format, v...)
 	}
 }
 
 // Errorf prints an error message to the system log.
 func (l *Logger) Errorf(format string, v ...interface{}) {
-	if l.level >= Error {
+	if l.GetLogLevel() >= Error {
 		l.Output(3, Labels[Error], format, v...)
 	}
 }
 
 // Warningf prints a warning message to the system log.
 func (l *Logger) Warningf(format string, v ...interface{}) {
-	if l.level >= Warning {
+	if l.GetLogLevel() >= Warning {
 		l.Output(3, Labels[Warning], format, v...)
 	}
 }
 
 // Noticef prints a notice message to the system log.
 func (l *Logger) Noticef(format string, v ...interface{}) {
-	if l.level >= Notice {
+	if l.GetLogLevel() >= Notice {
 		l.Output(3, Labels[Notice], format, v...)
 	}
 }
 
 // Infof prints an informational message to the system log.
 func (l *Logger) Infof(format string, v ...interface{}) {
-	if l.level >= Info {
+	if l.GetLogLevel() >= Info {
 		l.Output(3, Labels[Info], format, v...)
 	}
 }
 
 // Debugf prints a debug message to the system log.
 func (l *Logger) Debugf(format string, v ...interface{}) {
-	if l.level >= Debug {
+	if l.GetLogLevel() >= Debug {
 		l.Output(3, Labels[Debug], format, v...)
 	}
 }
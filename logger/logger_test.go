@@ -48,6 +48,22 @@ func TestDefaultSetLogLevel(t *testing.T) {
 	}
 }
 
+func TestSetLogLevelWhileLogging(t *testing.T) {
+	l := New(Debug, false)
+	l.logger.SetOutput(io.Discard)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			l.Debugf("Debugf %d", i)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		l.SetLogLevel(Info + i%2)
+	}
+	<-done
+}
+
 func TestSetErrorFunc(t *testing.T) {
 	l := New(Debug, false)
 	if err := l.SetExitFunc(nil); err == nil {
@@ -0,0 +1,248 @@
+// Package protocol implements the binary frame format spoken over the ingest websocket.
+//
+// Every websocket binary message carries exactly one frame. All integers are big endian.
+//
+//	offset  size  field
+//	0       1     version   - protocol version, currently 1.
+//	1       1     type      - frame type, see the Type constants.
+//	2       2     reserved  - must be zero.
+//	4       8     sequence  - client assigned id of the frame, echoed back in replies.
+//	12      4     length    - number of payload bytes that follow.
+//	16      n     payload   - depends on the type:
+//	                Data:  4 byte signed integer value.
+//	                Batch: one or more 4 byte signed integer values.
+//	                Ack:   empty.
+//	                Error: 2 byte error code followed by a UTF-8 message.
+//	                Busy:  4 byte milliseconds to wait before sending the frame again.
+//
+// Each value carries its own sequence. A Data frame's value has the frame sequence, and the
+// values in a Batch frame have consecutive sequences starting from the frame sequence. An Ack is
+// cumulative: it carries the highest sequence accepted so far on the connection, so a client
+// may send many frames before reading the acks. Frames answered with Busy are not accepted, as
+// the server is full, and neither are any frames after them until they are sent again, so an ack
+// never covers a frame still to be resent: the client should slow down, then go back and send
+// them all again once the retry-after has passed. Frames answered with an Error are dropped for
+// good, and a later ack covering the sequence does not accept them.
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Type identifies what a frame carries.
+type Type uint8
+
+// Frame types.
+const (
+	TypeData  Type = 0x01 // Client to server: a value to ingest.
+	TypeAck   Type = 0x02 // Server to client: the frame with this sequence was accepted.
+	TypeError Type = 0x03 // Server to client: the frame with this sequence was rejected.
+	TypeBatch Type = 0x04 // Client to server: many values to ingest.
+	TypeBusy  Type = 0x05 // Server to client: the frame with this sequence wasn't accepted as the server is full.
+)
+
+// Error codes carried in an Error frame.
+const (
+	CodeMalformed uint16 = 1 // The frame could not be decoded.
+	CodeVersion   uint16 = 2 // The frame version is not supported.
+	CodeType      uint16 = 3 // The frame type is unknown or not expected by the server.
+	CodeInternal  uint16 = 4 // The server could not process a valid frame.
+	CodeTooLarge  uint16 = 5 // The batch holds more values than the server can accept at once.
+)
+
+const (
+	Version     byte = 1       // The protocol version of frames encoded by this package.
+	HeaderSize       = 16      // Bytes in a frame header.
+	MaxPayload       = 1 << 20 // The largest payload accepted in a frame.
+	dataSize         = 4       // Bytes in a Data payload.
+	errCodeSize      = 2       // Bytes of error code leading an Error payload.
+	busySize         = 4       // Bytes in a Busy payload.
+)
+
+var (
+	ErrShort    = errors.New("protocol: frame shorter than header")
+	ErrVersion  = errors.New("protocol: unsupported version")
+	ErrType     = errors.New("protocol: unknown frame type")
+	ErrReserved = errors.New("protocol: reserved bytes not zero")
+	ErrLength   = errors.New("protocol: payload length does not match frame")
+	ErrPayload  = errors.New("protocol: payload invalid for frame type")
+)
+
+// Frame is a single decoded message.
+type Frame struct {
+	Version byte   // Protocol version.
+	Type    Type   // What the frame carries.
+	Seq     uint64 // Client assigned id of the frame.
+	Payload []byte // Type specific contents.
+}
+
+// DataFrameNew is a factory function that returns a new Data frame carrying value.
+func DataFrameNew(seq uint64, value int32) *Frame {
+	p := make([]byte, dataSize)
+	binary.BigEndian.PutUint32(p, uint32(value))
+	return &Frame{Version: Version, Type: TypeData, Seq: seq, Payload: p}
+}
+
+// BatchFrameNew is a factory function that returns a new Batch frame carrying values.
+func BatchFrameNew(seq uint64, values []int32) *Frame {
+	p := make([]byte, dataSize*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(p[i*dataSize:], uint32(v))
+	}
+	return &Frame{Version: Version, Type: TypeBatch, Seq: seq, Payload: p}
+}
+
+// AckFrameNew is a factory function that returns a new Ack frame for seq.
+func AckFrameNew(seq uint64) *Frame {
+	return &Frame{Version: Version, Type: TypeAck, Seq: seq}
+}
+
+// ErrorFrameNew is a factory function that returns a new Error frame for seq.
+func ErrorFrameNew(seq uint64, code uint16, msg string) *Frame {
+	p := make([]byte, errCodeSize+len(msg))
+	binary.BigEndian.PutUint16(p, code)
+	copy(p[errCodeSize:], msg)
+	return &Frame{Version: Version, Type: TypeError, Seq: seq, Payload: p}
+}
+
+// BusyFrameNew is a factory function that returns a new Busy frame for seq, asking the client
+// to wait retryAfter before sending it again. retryAfter is carried in whole milliseconds.
+func BusyFrameNew(seq uint64, retryAfter time.Duration) *Frame {
+	ms := retryAfter.Milliseconds()
+	ms = max(0, min(ms, math.MaxUint32))
+	p := make([]byte, busySize)
+	binary.BigEndian.PutUint32(p, uint32(ms))
+	return &Frame{Version: Version, Type: TypeBusy, Seq: seq, Payload: p}
+}
+
+// Encode returns the wire bytes of a frame.
+func Encode(f *Frame) []byte {
+	b := make([]byte, HeaderSize+len(f.Payload))
+	b[0] = f.Version
+	b[1] = byte(f.Type)
+	binary.BigEndian.PutUint64(b[4:12], f.Seq)
+	binary.BigEndian.PutUint32(b[12:16], uint32(len(f.Payload)))
+	copy(b[HeaderSize:], f.Payload)
+	return b
+}
+
+// Decode parses and validates a frame from its wire bytes. If the header could be read the
+// frame is returned along with any error, so the sequence can be echoed back in the reply.
+func Decode(b []byte) (*Frame, error) {
+	if len(b) < HeaderSize {
+		return nil, ErrShort
+	}
+	f := &Frame{
+		Version: b[0],
+		Type:    Type(b[1]),
+		Seq:     binary.BigEndian.Uint64(b[4:12]),
+	}
+	if f.Version != Version {
+		return f, ErrVersion
+	}
+	if b[2] != 0 || b[3] != 0 {
+		return f, ErrReserved
+	}
+	length := binary.BigEndian.Uint32(b[12:16])
+	if length > MaxPayload || int(length) != len(b)-HeaderSize {
+		return f, ErrLength
+	}
+	f.Payload = b[HeaderSize:]
+
+	switch f.Type {
+	case TypeData:
+		if len(f.Payload) != dataSize {
+			return f, ErrPayload
+		}
+	case TypeBatch:
+		if len(f.Payload) == 0 || len(f.Payload)%dataSize != 0 {
+			return f, ErrPayload
+		}
+		if f.LastSeq() < f.Seq { // The values would take sequences past the largest.
+			return f, ErrPayload
+		}
+	case TypeAck:
+		if len(f.Payload) != 0 {
+			return f, ErrPayload
+		}
+	case TypeError:
+		if len(f.Payload) < errCodeSize {
+			return f, ErrPayload
+		}
+	case TypeBusy:
+		if len(f.Payload) != busySize {
+			return f, ErrPayload
+		}
+	default:
+		return f, ErrType
+	}
+	return f, nil
+}
+
+// Value returns the value carried in a Data frame.
+func (f *Frame) Value() (int32, error) {
+	if f.Type != TypeData || len(f.Payload) != dataSize {
+		return 0, ErrPayload
+	}
+	return int32(binary.BigEndian.Uint32(f.Payload)), nil
+}
+
+// Values returns the values carried in a Data or Batch frame.
+func (f *Frame) Values() ([]int32, error) {
+	if (f.Type != TypeData && f.Type != TypeBatch) || len(f.Payload) == 0 ||
+		len(f.Payload)%dataSize != 0 {
+		return nil, ErrPayload
+	}
+	values := make([]int32, len(f.Payload)/dataSize)
+	for i := range values {
+		values[i] = int32(binary.BigEndian.Uint32(f.Payload[i*dataSize:]))
+	}
+	return values, nil
+}
+
+// LastSeq returns the sequence of the last value in a frame.
+func (f *Frame) LastSeq() uint64 {
+	if f.Type == TypeBatch && len(f.Payload) >= dataSize {
+		return f.Seq + uint64(len(f.Payload)/dataSize) - 1
+	}
+	return f.Seq
+}
+
+// Error returns the code and message carried in an Error frame.
+func (f *Frame) Error() (uint16, string, error) {
+	if f.Type != TypeError || len(f.Payload) < errCodeSize {
+		return 0, "", ErrPayload
+	}
+	return binary.BigEndian.Uint16(f.Payload), string(f.Payload[errCodeSize:]), nil
+}
+
+// RetryAfter returns how long a Busy frame asks the client to wait before sending again.
+func (f *Frame) RetryAfter() (time.Duration, error) {
+	if f.Type != TypeBusy || len(f.Payload) != busySize {
+		return 0, ErrPayload
+	}
+	return time.Duration(binary.BigEndian.Uint32(f.Payload)) * time.Millisecond, nil
+}
+
+// String is an implentation of the Stringer interface so the frame is returned as a string
+// to fmt.Print() etc.
+func (f *Frame) String() string {
+	return fmt.Sprintf("{version:%d type:%d seq:%d length:%d}", f.Version, f.Type, f.Seq, len(f.Payload))
+}
+
+// ErrorCode maps a Decode error to the code to reply with in an Error frame.
+func ErrorCode(err error) uint16 {
+	switch err {
+	case ErrVersion:
+		return CodeVersion
+	case ErrType:
+		return CodeType
+	case ErrShort, ErrReserved, ErrLength, ErrPayload:
+		return CodeMalformed
+	}
+	return CodeInternal
+}
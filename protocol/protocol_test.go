@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// Golden frames. Clients in other languages should produce and accept these exact bytes.
+var testGoldenFrames = []struct {
+	name  string
+	frame *Frame
+	hex   string
+}{
+	{
+		name:  "data",
+		frame: DataFrameNew(1, 42),
+		hex:   "01010000" + "0000000000000001" + "00000004" + "0000002a",
+	},
+	{
+		name:  "data negative",
+		frame: DataFrameNew(0x0102030405060708, -2),
+		hex:   "01010000" + "0102030405060708" + "00000004" + "fffffffe",
+	},
+	{
+		name:  "batch",
+		frame: BatchFrameNew(100, []int32{1, -1, 256}),
+		hex:   "01040000" + "0000000000000064" + "0000000c" + "00000001" + "ffffffff" + "00000100",
+	},
+	{
+		name:  "ack",
+		frame: AckFrameNew(7),
+		hex:   "01020000" + "0000000000000007" + "00000000",
+	},
+	{
+		name:  "error",
+		frame: ErrorFrameNew(9, CodeMalformed, "bad"),
+		hex:   "01030000" + "0000000000000009" + "00000005" + "0001" + "626164",
+	},
+	{
+		name:  "busy",
+		frame: BusyFrameNew(11, 250*time.Millisecond),
+		hex:   "01050000" + "000000000000000b" + "00000004" + "000000fa",
+	},
+}
+
+func TestEncodeGolden(t *testing.T) {
+	t.Parallel()
+	for _, g := range testGoldenFrames {
+		actual := hex.EncodeToString(Encode(g.frame))
+		if actual != g.hex {
+			t.Errorf("Frame %s not encoded correctly.\n\nExpected: %s\n\nActual: %s\n", g.name, g.hex, actual)
+		}
+	}
+}
+
+func TestDecodeGolden(t *testing.T) {
+	t.Parallel()
+	for _, g := range testGoldenFrames {
+		b, _ := hex.DecodeString(g.hex)
+		f, err := Decode(b)
+		if err != nil {
+			t.Errorf("Frame %s not decoded: %s", g.name, err)
+			continue
+		}
+		if f.Version != g.frame.Version || f.Type != g.frame.Type || f.Seq != g.frame.Seq ||
+			!bytes.Equal(f.Payload, g.frame.Payload) {
+			t.Errorf("Frame %s not decoded correctly.\n\nExpected: %s\n\nActual: %s\n", g.name, g.frame, f)
+		}
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	t.Parallel()
+	f, _ := Decode(Encode(DataFrameNew(3, -12345)))
+	if v, err := f.Value(); err != nil || v != -12345 {
+		t.Errorf("Data value incorrect.\n\nExpected: -12345\n\nActual: %d %v\n", v, err)
+	}
+	if _, err := AckFrameNew(3).Value(); err != ErrPayload {
+		t.Errorf("Value of an ack should return ErrPayload, received %v.", err)
+	}
+
+	f, _ = Decode(Encode(BatchFrameNew(10, []int32{5, 6, 7})))
+	if vals, err := f.Values(); err != nil || len(vals) != 3 || vals[0] != 5 || vals[2] != 7 {
+		t.Errorf("Batch values incorrect.\n\nExpected: [5 6 7]\n\nActual: %v %v\n", vals, err)
+	}
+	if f.LastSeq() != 12 {
+		t.Errorf("Batch last sequence incorrect.\n\nExpected: 12\n\nActual: %d\n", f.LastSeq())
+	}
+	f, _ = Decode(Encode(DataFrameNew(3, 9)))
+	if vals, err := f.Values(); err != nil || len(vals) != 1 || vals[0] != 9 || f.LastSeq() != 3 {
+		t.Errorf("Data values incorrect. Received %v %v %d.", vals, err, f.LastSeq())
+	}
+
+	f, _ = Decode(Encode(ErrorFrameNew(4, CodeType, "nope")))
+	code, msg, err := f.Error()
+	if err != nil || code != CodeType || msg != "nope" {
+		t.Errorf("Error frame incorrect. Received %d %q %v.", code, msg, err)
+	}
+
+	f, _ = Decode(Encode(BusyFrameNew(6, 1500*time.Millisecond)))
+	if d, err := f.RetryAfter(); err != nil || d != 1500*time.Millisecond {
+		t.Errorf("Busy retry-after incorrect.\n\nExpected: 1.5s\n\nActual: %s %v\n", d, err)
+	}
+	if _, err := AckFrameNew(6).RetryAfter(); err != ErrPayload {
+		t.Errorf("RetryAfter of an ack should return ErrPayload, received %v.", err)
+	}
+	if d, _ := BusyFrameNew(6, -time.Second).RetryAfter(); d != 0 {
+		t.Errorf("Negative retry-after should be sent as 0, received %s.", d)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		hex  string
+		err  error
+		code uint16
+	}{
+		{"short", "010100000000", ErrShort, CodeMalformed},
+		{"version", "02010000" + "0000000000000005" + "00000004" + "0000002a", ErrVersion, CodeVersion},
+		{"type", "01090000" + "0000000000000005" + "00000000", ErrType, CodeType},
+		{"reserved", "01010100" + "0000000000000005" + "00000004" + "0000002a", ErrReserved, CodeMalformed},
+		{"length long", "01010000" + "0000000000000005" + "00000008" + "0000002a", ErrLength, CodeMalformed},
+		{"length short", "01010000" + "0000000000000005" + "00000002" + "0000002a", ErrLength, CodeMalformed},
+		{"data size", "01010000" + "0000000000000005" + "00000002" + "002a", ErrPayload, CodeMalformed},
+		{"batch empty", "01040000" + "0000000000000005" + "00000000", ErrPayload, CodeMalformed},
+		{"batch size", "01040000" + "0000000000000005" + "00000006" + "0000002a0000", ErrPayload, CodeMalformed},
+		{"batch wraps", "01040000" + "ffffffffffffffff" + "00000008" + "0000002a0000002a", ErrPayload, CodeMalformed},
+		{"ack payload", "01020000" + "0000000000000005" + "00000001" + "00", ErrPayload, CodeMalformed},
+		{"error code", "01030000" + "0000000000000005" + "00000001" + "00", ErrPayload, CodeMalformed},
+		{"busy size", "01050000" + "0000000000000005" + "00000002" + "0000", ErrPayload, CodeMalformed},
+	}
+	for _, tc := range tests {
+		b, _ := hex.DecodeString(tc.hex)
+		f, err := Decode(b)
+		if err != tc.err {
+			t.Errorf("Frame %s should fail with %v, received %v.", tc.name, tc.err, err)
+		}
+		if code := ErrorCode(err); code != tc.code {
+			t.Errorf("Frame %s should map to code %d, received %d.", tc.name, tc.code, code)
+		}
+		if err != ErrShort && (f == nil || f.Seq != binary.BigEndian.Uint64(b[4:12])) {
+			t.Errorf("Frame %s should return the header so the sequence can be echoed.", tc.name)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package ringbuffer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// The benchmarks below each pass b.N int64 values from publishers to consumers, so ns/op is
+// the cost of moving one value end to end. Compare against BenchmarkChannel, which does the
+// same through a buffered channel:
+//
+//	go test -run none -bench . -benchmem -cpu 1,4 ./ringbuffer
+
+// benchmarkLoads are the publisher and consumer go routine counts compared.
+var benchmarkLoads = []struct {
+	producers, consumers int
+}{
+	{1, 1}, {2, 2}, {4, 1}, {4, 4}, {8, 8},
+}
+
+func BenchmarkSeqSimple(b *testing.B) {
+	for _, size := range []int64{64, 1024, 65536} {
+		b.Run(fmt.Sprintf("ring%d", size), func(b *testing.B) {
+			benchmarkSimple(b, SequenceManagerNew(size))
+		})
+	}
+}
+
+func BenchmarkSeqMultiBatch(b *testing.B) {
+	for _, batch := range []int64{1, 4, 16, 64, 256} {
+		b.Run(fmt.Sprintf("batch%d", batch), func(b *testing.B) {
+			benchmarkMulti(b, ManagerNew(1024), 1, 1, batch)
+		})
+	}
+}
+
+func BenchmarkSeqMultiRing(b *testing.B) {
+	for _, size := range []int64{64, 1024, 65536} {
+		b.Run(fmt.Sprintf("ring%d", size), func(b *testing.B) {
+			benchmarkMulti(b, ManagerNew(size), 4, 4, 1)
+		})
+	}
+}
+
+func BenchmarkSeqMultiLoad(b *testing.B) {
+	for _, ld := range benchmarkLoads {
+		b.Run(fmt.Sprintf("%dP%dC", ld.producers, ld.consumers), func(b *testing.B) {
+			benchmarkMulti(b, ManagerNew(1024), ld.producers, ld.consumers, 1)
+		})
+	}
+}
+
+func BenchmarkRingBuffer(b *testing.B) {
+	for _, batch := range []int64{1, 16, 256} {
+		b.Run(fmt.Sprintf("batch%d", batch), func(b *testing.B) {
+			r := RingBufferNew[int64](1024)
+			var sum int64
+			done := make(chan bool)
+			b.ReportAllocs()
+			b.ResetTimer()
+			go func() {
+				for n := int64(0); n < int64(b.N); n += batch {
+					r.ConsumeBatch(min(batch, int64(b.N)-n), func(seq int64, v *int64) { sum += *v })
+				}
+				close(done)
+			}()
+			for n := int64(0); n < int64(b.N); n += batch {
+				r.PublishBatch(min(batch, int64(b.N)-n), func(seq int64, v *int64) { *v = 1 })
+			}
+			<-done
+			benchmarkCheck(b, sum)
+		})
+	}
+}
+
+func BenchmarkChannel(b *testing.B) {
+	for _, size := range []int{64, 65536} { // 1024 is covered by the loads.
+		b.Run(fmt.Sprintf("ring%d/1P1C", size), func(b *testing.B) {
+			benchmarkChannel(b, size, 1, 1)
+		})
+	}
+	for _, ld := range benchmarkLoads {
+		b.Run(fmt.Sprintf("ring1024/%dP%dC", ld.producers, ld.consumers), func(b *testing.B) {
+			benchmarkChannel(b, 1024, ld.producers, ld.consumers)
+		})
+	}
+}
+
+// benchmarkShares splits b.N values between go routines.
+func benchmarkShares(b *testing.B, routines int) []int64 {
+	shares := make([]int64, routines)
+	for i := range shares {
+		shares[i] = int64(b.N / routines)
+	}
+	shares[0] += int64(b.N % routines)
+	return shares
+}
+
+// benchmarkCheck fails the benchmark if any value was lost.
+func benchmarkCheck(b *testing.B, sum int64) {
+	if sum != int64(b.N) {
+		b.Fatalf("Lost values: expected %d, received %d.", b.N, sum)
+	}
+}
+
+// benchmarkSimple passes b.N values from one go routine to another through SeqSimple.
+func benchmarkSimple(b *testing.B, m *SequenceManager) {
+	ring := make([]int64, m.Leader.buffSize)
+	mask := m.Leader.Mask()
+	var sum int64
+	done := make(chan bool)
+	b.ReportAllocs()
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			j := m.Follower.Reserve()
+			sum += ring[j&mask]
+			m.Follower.Commit(j)
+		}
+		close(done)
+	}()
+	for i := 0; i < b.N; i++ {
+		j := m.Leader.Reserve()
+		ring[j&mask] = 1
+		m.Leader.Commit(j)
+	}
+	<-done
+	benchmarkCheck(b, sum)
+}
+
+// benchmarkMulti passes b.N values from producers to consumers through SeqMulti, reserving up
+// to batch cells at a time.
+func benchmarkMulti(b *testing.B, m *Manager, producers, consumers int, batch int64) {
+	ring := make([]int64, m.Leader.buffSize)
+	mask := m.Leader.Mask()
+	var sum int64
+	var wg sync.WaitGroup
+	run := func(s *SeqMulti, routines int, work func(lower, upper int64)) {
+		for _, share := range benchmarkShares(b, routines) {
+			wg.Add(1)
+			go func(share int64) {
+				defer wg.Done()
+				for n := int64(0); n < share; n += batch {
+					count := min(batch, share-n)
+					upper := s.Reserve(count)
+					work(upper-count+1, upper)
+					s.Commit(upper-count+1, upper)
+				}
+			}(share)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	run(m.Follower, consumers, func(lower, upper int64) {
+		var local int64
+		for i := lower; i <= upper; i++ {
+			local += ring[i&mask]
+		}
+		atomic.AddInt64(&sum, local)
+	})
+	run(m.Leader, producers, func(lower, upper int64) {
+		for i := lower; i <= upper; i++ {
+			ring[i&mask] = 1
+		}
+	})
+	wg.Wait()
+	benchmarkCheck(b, sum)
+}
+
+// benchmarkChannel passes b.N values from producers to consumers through a buffered channel of
+// size, as a baseline for the ring.
+func benchmarkChannel(b *testing.B, size, producers, consumers int) {
+	ch := make(chan int64, size)
+	var sum int64
+	var wg sync.WaitGroup
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, share := range benchmarkShares(b, consumers) {
+		wg.Add(1)
+		go func(share int64) {
+			defer wg.Done()
+			var local int64
+			for n := int64(0); n < share; n++ {
+				local += <-ch
+			}
+			atomic.AddInt64(&sum, local)
+		}(share)
+	}
+	for _, share := range benchmarkShares(b, producers) {
+		wg.Add(1)
+		go func(share int64) {
+			defer wg.Done()
+			for n := int64(0); n < share; n++ {
+				ch <- 1
+			}
+		}(share)
+	}
+	wg.Wait()
+	benchmarkCheck(b, sum)
+}
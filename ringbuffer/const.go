@@ -1,6 +1,20 @@
 package ringbuffer
 
+import "time"
+
 const (
 	SequenceMax     int64 = (1 << 63) - 1
-	SequenceDefault int64 = -1 // For iinitiializing seq and commit buffer
+	SequenceDefault int64 = -1      // For iinitiializing seq and commit buffer
+	SizeMax         int64 = 1 << 30 // Largest compact ring supported, bounding the memory of the ring and commit maps.
+
+	// Wait strategy names.
+	WaitBusySpin = "busyspin" // Spin in a tight loop.
+	WaitYielding = "yielding" // Spin but yield the processor between polls.
+	WaitSleeping = "sleeping" // Spin, yield, then sleep with backoff.
+	WaitBlocking = "blocking" // Block until signalled by a commit.
+
+	sleepingWaitSpins  = 100                  // Polls before a SleepingWait yields.
+	sleepingWaitYields = 100                  // Yields before a SleepingWait sleeps.
+	sleepingWaitMin    = 1 * time.Microsecond // First sleep period of a SleepingWait.
+	sleepingWaitMax    = 1 * time.Millisecond // Longest sleep period of a SleepingWait.
 )
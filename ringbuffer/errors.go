@@ -0,0 +1,8 @@
+package ringbuffer
+
+import "errors"
+
+var (
+	// ErrUnavailable is returned when the dependency has not freed the cells requested in time.
+	ErrUnavailable = errors.New("ringbuffer: no cells available")
+)
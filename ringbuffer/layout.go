@@ -0,0 +1,58 @@
+package ringbuffer
+
+// Layout selects how a sequence places the values it writes in memory. Go routines on
+// different cores writing values that share a cache line ("false sharing") bounce the line
+// between the cores, even though they never touch the same value.
+type Layout int
+
+const (
+	// LayoutCompact packs the commit cells together and allocates the cursor on its own.
+	// It uses the least memory, but neighbouring cells and the cursor may share cache lines.
+	LayoutCompact Layout = iota
+
+	// LayoutPadded gives the cursor and each commit cell a cache line of their own. The commit
+	// map takes a cache line per cell: 64 bytes rather than 8, so it is opt in.
+	LayoutPadded
+)
+
+const (
+	cacheLine     = 64            // Bytes in a cache line on common processors.
+	cellStride    = cacheLine / 8 // int64 positions between padded commit cells.
+	cursorPad     = cacheLine - 8 // Bytes either side of a padded cursor.
+	LayoutDefault = LayoutCompact // The layout of new sequences.
+)
+
+// paddedCursor keeps a cursor value clear of anything else that might share its cache line.
+type paddedCursor struct {
+	_     [cursorPad]byte
+	value int64
+	_     [cursorPad]byte
+}
+
+// String is an implentation of the Stringer interface so the layout is returned as a name.
+func (l Layout) String() string {
+	if l == LayoutPadded {
+		return "padded"
+	}
+	return "compact"
+}
+
+// SizeMax returns the largest ring supported in the layout, so its commit map takes no more
+// memory than a compact one of SizeMax cells.
+func (l Layout) SizeMax() int64 {
+	if l == LayoutPadded {
+		return SizeMax / cellStride
+	}
+	return SizeMax
+}
+
+// layoutAlloc returns a new cursor and commit map for a ring of size in layout l, along with
+// the stride between cells in the commit map.
+func layoutAlloc(l Layout, size int64) (*int64, []int64, int64) {
+	if l != LayoutPadded {
+		return new(int64), make([]int64, size), 1
+	}
+	// Cells sit at the end of each line, so the first also has a line of padding before it.
+	c := make([]int64, size*cellStride+cellStride-1)
+	return &new(paddedCursor).value, c[cellStride-1:], cellStride
+}
@@ -0,0 +1,66 @@
+package ringbuffer
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestLayoutPadded(t *testing.T) {
+	t.Parallel()
+	if m := ManagerNew(8); m.Leader.stride != 1 || m.Follower.stride != 1 {
+		t.Errorf("New sequences should use the compact layout, padding is opt in.")
+	}
+	for _, l := range []Layout{LayoutCompact, LayoutPadded} {
+		m := ManagerNew(8)
+		m.SetLayout(l)
+		gap := uintptr(unsafe.Pointer(m.Leader.cell(1))) - uintptr(unsafe.Pointer(m.Leader.cell(0)))
+		if (l == LayoutPadded && gap != cacheLine) || (l == LayoutCompact && gap != 8) {
+			t.Errorf("Layout %s places cells %d bytes apart.", l, gap)
+		}
+
+		if l.SizeMax()*int64(gap) > SizeMax*8 {
+			t.Errorf("Layout %s allows a commit map larger than a compact one.", l)
+		}
+
+		// Both layouts must behave the same.
+		for i := 0; i < 3; i++ {
+			j := m.Leader.Reserve(8)
+			m.Leader.Commit(j-7, j)
+			if _, err := m.Leader.TryReserve(1); err != ErrUnavailable {
+				t.Fatalf("Layout %s: expected a full ring, received %v.", l, err)
+			}
+			j = m.Follower.Reserve(8)
+			m.Follower.Commit(j-7, j)
+			if _, err := m.Follower.TryReserve(1); err != ErrUnavailable {
+				t.Fatalf("Layout %s: expected an empty ring, received %v.", l, err)
+			}
+		}
+	}
+}
+
+// BenchmarkLayout compares the compact and padded layouts as go routines pass b.N cells
+// through a ring. Run with -cpu to see the effect of false sharing across cores.
+func BenchmarkLayout(b *testing.B) {
+	loads := []struct {
+		name                 string
+		producers, consumers int
+	}{
+		{"1P1C", 1, 1},
+		{"4P1C", 4, 1},
+		{"4P4C", 4, 4},
+	}
+	for _, l := range []Layout{LayoutCompact, LayoutPadded} {
+		b.Run(l.String()+"/simple", func(b *testing.B) {
+			m := SequenceManagerNew(1024)
+			m.SetLayout(l)
+			benchmarkSimple(b, m)
+		})
+		for _, ld := range loads {
+			b.Run(l.String()+"/"+ld.name, func(b *testing.B) {
+				m := ManagerNew(1024)
+				m.SetLayout(l)
+				benchmarkMulti(b, m, ld.producers, ld.consumers, 1)
+			})
+		}
+	}
+}
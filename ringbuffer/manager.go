@@ -0,0 +1,66 @@
+package ringbuffer
+
+import "sync/atomic"
+
+// Manager is a wrapper around the components for managing a ringbuffer shared by many
+// publishing and consuming go routines.
+type Manager struct {
+	Leader   *SeqMulti
+	Follower *SeqMulti
+}
+
+// ManagerNew instatiates and returns a new Manager.
+func ManagerNew(size int64) *Manager {
+	m := &Manager{
+		Leader:   SeqMultiNew(size, nil, true),
+		Follower: SeqMultiNew(size, nil, false),
+	}
+
+	// Set the dependencies.
+	m.Leader.SetDependency(m.Follower)
+	m.Follower.SetDependency(m.Leader)
+	return m
+}
+
+// Occupancy returns the number of cells reserved by the Leader that the Follower has not yet
+// reserved. It is a snapshot and may be stale as soon as it returns.
+func (m *Manager) Occupancy() int64 {
+	return m.Leader.Cursor() - m.Follower.Cursor()
+}
+
+// Pending returns the number of cells reserved by the Leader that the Follower has not yet
+// committed. It scans the commit map, so is meant for occasional checks such as at shutdown.
+func (m *Manager) Pending() int64 {
+	return m.PendingAt(m.Follower)
+}
+
+// PendingAt returns the number of cells reserved by the Leader that stage, the Follower or a
+// pipeline stage ahead of it, has not yet committed.
+func (m *Manager) PendingAt(stage *SeqMulti) int64 {
+	upper := m.Leader.Cursor()
+	var n int64
+	for i := max(upper-m.Leader.buffSize+1, 0); i <= upper; i++ {
+		if atomic.LoadInt64(stage.cell(i)) < i {
+			n++
+		}
+	}
+	return n
+}
+
+// Consumed returns the highest index through which the Follower has committed every cell,
+// scanning on from after, the result of a previous call or SequenceDefault.
+func (m *Manager) Consumed(after int64) int64 {
+	upper := m.Leader.Cursor()
+	after = max(after, upper-m.Leader.buffSize) // The Leader has already seen these committed.
+	for after < upper && atomic.LoadInt64(m.Follower.cell(after+1)) >= after+1 {
+		after++
+	}
+	return after
+}
+
+// SetLayout sets how the Leader and Follower are placed in memory. It resets both, so should
+// only be called before the ring is used.
+func (m *Manager) SetLayout(l Layout) {
+	m.Leader.SetLayout(l)
+	m.Follower.SetLayout(l)
+}
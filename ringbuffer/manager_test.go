@@ -0,0 +1,288 @@
+package ringbuffer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManagerConcurrent(t *testing.T) {
+	testManagerLoad(t, 1024, 8, 4, 1, 10000)
+}
+
+func TestManagerConcurrentBatch(t *testing.T) {
+	testManagerLoad(t, 1024, 4, 4, 4, 10000)
+}
+
+func TestManagerConcurrentSmallRing(t *testing.T) {
+	testManagerLoad(t, 8, 8, 8, 1, 5000)
+}
+
+func TestManagerConcurrentMixedBatch(t *testing.T) {
+	m := ManagerNew(16)
+	ring := make([]int64, 16)
+	mask := m.Leader.Mask()
+	producers, perProducer := 4, 2000
+	total := producers * perProducer
+	seen := make([]int32, total)
+
+	// One consumer taking batches sized differently to the publishers.
+	done := make(chan bool)
+	go func() {
+		for n, batch := 0, int64(1); n < total; n += int(batch) {
+			batch = int64(n%5 + 1)
+			if rem := int64(total - n); batch > rem {
+				batch = rem
+			}
+			upper := m.Follower.Reserve(batch)
+			lower := upper - batch + 1
+			for i := lower; i <= upper; i++ {
+				seen[ring[i&mask]]++
+			}
+			m.Follower.Commit(lower, upper)
+		}
+		close(done)
+	}()
+
+	var pwg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		pwg.Add(1)
+		go func(p int) {
+			defer pwg.Done()
+			for n, batch := 0, int64(1); n < perProducer; n += int(batch) {
+				batch = int64((n+p)%7 + 1)
+				if rem := int64(perProducer - n); batch > rem {
+					batch = rem
+				}
+				upper := m.Leader.Reserve(batch)
+				lower := upper - batch + 1
+				for i := lower; i <= upper; i++ {
+					ring[i&mask] = int64(p*perProducer + n + int(i-lower))
+				}
+				m.Leader.Commit(lower, upper)
+			}
+		}(p)
+	}
+	finished := make(chan bool)
+	go func() {
+		pwg.Wait()
+		<-done
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Mixed batches did not get through the ring.")
+	}
+
+	for i, cnt := range seen {
+		if cnt != 1 {
+			t.Fatalf("Item %d consumed %d times.", i, cnt)
+		}
+	}
+}
+
+func TestManagerReserveUnavailable(t *testing.T) {
+	m := ManagerNew(4)
+	if _, err := m.Follower.TryReserve(1); err != ErrUnavailable {
+		t.Fatalf("Expected ErrUnavailable from an empty ring, received %v.", err)
+	}
+
+	j := m.Leader.Reserve(4)
+	m.Leader.Commit(j-3, j)
+	if _, err := m.Leader.TryReserve(1); err != ErrUnavailable {
+		t.Fatalf("Expected ErrUnavailable from a full ring, received %v.", err)
+	}
+	if _, err := m.Leader.ReserveTimeout(1, 10*time.Millisecond); err != ErrUnavailable {
+		t.Fatalf("Expected ErrUnavailable after timeout, received %v.", err)
+	}
+
+	// Cancel many blocked publishers at once.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			_, err := m.Leader.ReserveContext(ctx, 1)
+			errs <- err
+		}()
+	}
+	cancel()
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != context.Canceled {
+			t.Fatalf("Expected context.Canceled, received %v.", err)
+		}
+	}
+
+	if occ := m.Occupancy(); occ != 4 {
+		t.Fatalf("Expected occupancy 4 from a full ring, received %d.", occ)
+	}
+
+	// A failed reserve must not move the cursor.
+	j = m.Follower.Reserve(1)
+	m.Follower.Commit(j, j)
+	if occ := m.Occupancy(); occ != 3 {
+		t.Fatalf("Expected occupancy 3, received %d.", occ)
+	}
+	if j, err := m.Leader.TryReserve(1); err != nil || j != 4 {
+		t.Fatalf("Expected to reserve cell 4, received %d %v.", j, err)
+	}
+}
+
+func TestManagerPending(t *testing.T) {
+	for _, next := range []int64{0, 1 << 40} {
+		m := ManagerNew(8)
+		testFastForwardMulti(next, m.Leader, m.Follower)
+		if p := m.Pending(); p != 0 {
+			t.Fatalf("Empty ring at %d should have nothing pending, received %d.", next, p)
+		}
+		m.Leader.Commit(next, m.Leader.Reserve(5))
+
+		// Reserved but uncommitted work is still pending, even if later work is committed.
+		a := m.Follower.Reserve(2)
+		b := m.Follower.Reserve(1)
+		m.Follower.Commit(b, b)
+		if p := m.Pending(); p != 4 {
+			t.Errorf("Ring at %d should have 4 pending, received %d.", next, p)
+		}
+		c := m.Follower.Reserve(2)
+		m.Follower.Commit(a-1, a)
+		m.Follower.Commit(c-1, c)
+		if p := m.Pending(); p != 0 {
+			t.Errorf("Drained ring at %d should have nothing pending, received %d.", next, p)
+		}
+	}
+}
+
+func TestManagerPendingAt(t *testing.T) {
+	p := PipelineNew(8)
+	stage := p.AddStage()
+	m := &Manager{Leader: p.Leader, Follower: p.AddStage(stage)}
+	p.Build()
+	m.Leader.Commit(0, m.Leader.Reserve(5))
+
+	// Work the stage has committed is pending at the Follower only.
+	j := stage.Reserve(3)
+	stage.Commit(j-2, j)
+	if s, f := m.PendingAt(stage), m.Pending(); s != 2 || f != 5 {
+		t.Errorf("Expected 2 pending at the stage and 5 at the Follower, received %d %d.", s, f)
+	}
+}
+
+func TestManagerConsumed(t *testing.T) {
+	m := ManagerNew(4)
+	if c := m.Consumed(SequenceDefault); c != SequenceDefault {
+		t.Fatalf("Empty ring should have consumed nothing, received %d.", c)
+	}
+	m.Leader.Commit(0, m.Leader.Reserve(4))
+	a := m.Follower.Reserve(1)
+	b := m.Follower.Reserve(2)
+	m.Follower.Commit(b-1, b)
+	if c := m.Consumed(SequenceDefault); c != SequenceDefault {
+		t.Errorf("Consumed should stop at the first uncommitted cell, received %d.", c)
+	}
+	m.Follower.Commit(a, a)
+	if c := m.Consumed(SequenceDefault); c != 2 {
+		t.Errorf("Consumed should be 2, received %d.", c)
+	}
+
+	// Cells the Leader has reused were consumed a rotation ago.
+	m.Follower.Commit(m.Follower.Reserve(1), 3)
+	m.Leader.Commit(4, m.Leader.Reserve(3))
+	if c := m.Consumed(0); c != 3 {
+		t.Errorf("Consumed should be 3, received %d.", c)
+	}
+}
+
+// testFastForwardMulti moves the sequences on to next, as if all work before it has passed
+// through the ring.
+func testFastForwardMulti(next int64, seqs ...*SeqMulti) {
+	for _, s := range seqs {
+		*s.cursor = next - 1
+		for c := int64(0); c < s.buffSize; c++ {
+			*s.cell(next + c) = next + c - s.buffSize
+		}
+	}
+}
+
+func TestManagerOverflow(t *testing.T) {
+	for _, size := range []int64{2, 8, 1024} {
+		for _, next := range testOverflowPoints(size) {
+			m := ManagerNew(size)
+			testFastForwardMulti(next, m.Leader, m.Follower)
+			if _, err := m.Follower.TryReserve(1); err != ErrUnavailable {
+				t.Fatalf("Ring %d at %d: follower read past the leader, %v.", size, next, err)
+			}
+
+			// Publish a full ring in batches straddling the rotation, then check it is full.
+			for n := int64(0); n < size; {
+				batch := size - n
+				if batch > 3 {
+					batch = 3
+				}
+				upper := m.Leader.Reserve(batch)
+				m.Leader.Commit(upper-batch+1, upper)
+				n += batch
+			}
+			if _, err := m.Leader.TryReserve(1); err != ErrUnavailable {
+				t.Fatalf("Ring %d at %d: leader overwrote unread work, %v.", size, next, err)
+			}
+			if j, err := m.Follower.TryReserve(size); err != nil || j != next+size-1 {
+				t.Fatalf("Ring %d at %d: follower should read the whole ring, received %d %v.", size, next, j, err)
+			}
+		}
+	}
+}
+
+// testManagerLoad pushes items through a Manager from many publishers to many consumers and
+// validates every item arrives exactly once.
+func testManagerLoad(t *testing.T, size int64, producers, consumers int, batch int64, perProducer int) {
+	m := ManagerNew(size)
+	ring := make([]int64, size)
+	mask := m.Leader.Mask()
+	total := producers * perProducer
+	seen := make([]int32, total)
+	var mu sync.Mutex
+
+	var cwg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			for n := 0; n < total/consumers; n += int(batch) {
+				upper := m.Follower.Reserve(batch)
+				lower := upper - batch + 1
+				mu.Lock()
+				for i := lower; i <= upper; i++ {
+					seen[ring[i&mask]]++
+				}
+				mu.Unlock()
+				m.Follower.Commit(lower, upper)
+			}
+		}()
+	}
+
+	var pwg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		pwg.Add(1)
+		go func(p int) {
+			defer pwg.Done()
+			for n := 0; n < perProducer; n += int(batch) {
+				upper := m.Leader.Reserve(batch)
+				lower := upper - batch + 1
+				for i := lower; i <= upper; i++ {
+					ring[i&mask] = int64(p*perProducer + n + int(i-lower))
+				}
+				m.Leader.Commit(lower, upper)
+			}
+		}(p)
+	}
+	pwg.Wait()
+	cwg.Wait()
+
+	for i, cnt := range seen {
+		if cnt != 1 {
+			t.Fatalf("Item %d consumed %d times.", i, cnt)
+		}
+	}
+}
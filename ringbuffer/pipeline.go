@@ -0,0 +1,82 @@
+package ringbuffer
+
+import "errors"
+
+// Pipeline wires a Leader and any number of consumer stages over a single ringbuffer.
+// Each stage may depend on several upstream stages, and only sees a cell once all of them
+// have committed it. The Leader is gated on the terminal stages (those no other stage
+// depends on), so a cell is not reused until every path through the pipeline is done with it.
+//
+// For example, a journaler and a replicator both reading from the Leader, with business logic
+// waiting on both:
+//
+//	Leader -> journal   -> business -> Leader
+//	       -> replicate ->
+type Pipeline struct {
+	Leader *SeqMulti   // Synchronizes the work written to the buffer.
+	stages []*SeqMulti // Every stage added, in order.
+	gating []*SeqMulti // Stages that another stage depends on.
+	size   int64       // The length of the ringbuffer.
+	built  bool        // Has the Leader been gated?
+}
+
+// PipelineNew instatiates and returns a new Pipeline with a Leader and no stages.
+func PipelineNew(size int64) *Pipeline {
+	return &Pipeline{
+		Leader: SeqMultiNew(size, nil, true),
+		size:   size,
+	}
+}
+
+// AddStage returns a new stage that may process a cell once every one of deps has committed it.
+// With no deps the stage reads straight from the Leader.
+func (p *Pipeline) AddStage(deps ...*SeqMulti) *SeqMulti {
+	if len(deps) == 0 {
+		deps = []*SeqMulti{p.Leader}
+	}
+	s := SeqMultiNew(p.size, nil, false)
+	s.SetDependency(deps...)
+	p.stages = append(p.stages, s)
+	for _, d := range deps {
+		if d != p.Leader {
+			p.gating = append(p.gating, d)
+		}
+	}
+	return s
+}
+
+// Build gates the Leader on the terminal stages. It must be called once after the last stage
+// is added and before any go routine reserves from the ring.
+func (p *Pipeline) Build() error {
+	if p.built {
+		return errors.New("Pipeline already built.")
+	}
+	terminals := p.Terminals()
+	if len(terminals) == 0 {
+		return errors.New("Pipeline has no stages.")
+	}
+	p.Leader.SetDependency(terminals...)
+	p.built = true
+	return nil
+}
+
+// Terminals returns the stages that no other stage depends on.
+func (p *Pipeline) Terminals() []*SeqMulti {
+	var terminals []*SeqMulti
+	for _, s := range p.stages {
+		if !containsSeq(p.gating, s) {
+			terminals = append(terminals, s)
+		}
+	}
+	return terminals
+}
+
+// containsSeq returns whether s is in list.
+func containsSeq(list []*SeqMulti, s *SeqMulti) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
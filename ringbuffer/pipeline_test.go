@@ -0,0 +1,133 @@
+package ringbuffer
+
+import (
+	"sync"
+	"testing"
+)
+
+// testPipelineWork is a cell in the ring that each stage marks as it passes.
+type testPipelineWork struct {
+	value      int64
+	journaled  bool
+	replicated bool
+	validated  bool
+}
+
+func TestPipelineBuild(t *testing.T) {
+	t.Parallel()
+	p := PipelineNew(16)
+	if err := p.Build(); err == nil {
+		t.Fatalf("Pipeline without stages should not build.")
+	}
+
+	a := p.AddStage()
+	b := p.AddStage()
+	c := p.AddStage(a, b)
+	terminals := p.Terminals()
+	if len(terminals) != 1 || terminals[0] != c {
+		t.Fatalf("Pipeline terminals incorrect. Expected only the last stage.")
+	}
+	if err := p.Build(); err != nil {
+		t.Fatalf("Pipeline should build: %s", err)
+	}
+	if err := p.Build(); err == nil {
+		t.Fatalf("Pipeline should not build twice.")
+	}
+}
+
+func TestPipelineDiamond(t *testing.T) {
+	size := int64(64)
+	total := 20000
+	ring := make([]testPipelineWork, size)
+	p := PipelineNew(size)
+	journal := p.AddStage()
+	replicate := p.AddStage()
+	business := p.AddStage(journal, replicate)
+	if err := p.Build(); err != nil {
+		t.Fatalf("Pipeline should build: %s", err)
+	}
+	mask := p.Leader.Mask()
+
+	var wg sync.WaitGroup
+	stage := func(s *SeqMulti, workers int, process func(w *testPipelineWork)) {
+		for c := 0; c < workers; c++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; n < total/workers; n++ {
+					j := s.Reserve(1)
+					process(&ring[j&mask])
+					s.Commit(j, j)
+				}
+			}()
+		}
+	}
+
+	var mu sync.Mutex
+	var sum int64
+	stage(journal, 2, func(w *testPipelineWork) { w.journaled = true })
+	stage(replicate, 1, func(w *testPipelineWork) { w.replicated = true })
+	stage(business, 4, func(w *testPipelineWork) {
+		if !w.journaled || !w.replicated {
+			t.Errorf("Business stage ran before journal and replicate on %d.", w.value)
+		}
+		mu.Lock()
+		sum += w.value
+		mu.Unlock()
+	})
+
+	for i := 1; i <= total; i++ {
+		j := p.Leader.Reserve(1)
+		ring[j&mask] = testPipelineWork{value: int64(i)}
+		p.Leader.Commit(j, j)
+	}
+	wg.Wait()
+
+	if expected := int64(total * (total + 1) / 2); sum != expected {
+		t.Fatalf("Pipeline lost work.\n\nExpected: %d\n\nActual: %d\n", expected, sum)
+	}
+}
+
+func TestPipelineChain(t *testing.T) {
+	size := int64(16)
+	total := 10000
+	ring := make([]testPipelineWork, size)
+	p := PipelineNew(size)
+	validate := p.AddStage()
+	journal := p.AddStage(validate)
+	replicate := p.AddStage(journal)
+	p.Build()
+	mask := p.Leader.Mask()
+
+	var wg sync.WaitGroup
+	run := func(s *SeqMulti, process func(w *testPipelineWork)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < total; n++ {
+				j := s.Reserve(1)
+				process(&ring[j&mask])
+				s.Commit(j, j)
+			}
+		}()
+	}
+	run(validate, func(w *testPipelineWork) { w.validated = true })
+	run(journal, func(w *testPipelineWork) {
+		if !w.validated {
+			t.Errorf("Journal stage ran before validate on %d.", w.value)
+		}
+		w.journaled = true
+	})
+	run(replicate, func(w *testPipelineWork) {
+		if !w.journaled {
+			t.Errorf("Replicate stage ran before journal on %d.", w.value)
+		}
+	})
+
+	for i := 0; i < total; i++ {
+		j := p.Leader.Reserve(1)
+		ring[j&mask] = testPipelineWork{value: int64(i)}
+		p.Leader.Commit(j, j)
+	}
+	wg.Wait()
+}
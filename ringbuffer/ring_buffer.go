@@ -0,0 +1,74 @@
+package ringbuffer
+
+// RingBuffer is a pre-allocated ring of slots of any type along with the Leader and Follower
+// sequences that synchronize access to it. It is meant for one publisher and one consumer
+// go routine, and saves the caller from the Reserve/Commit and index masking boilerplate.
+type RingBuffer[T any] struct {
+	slots []T              // The pre-allocated work.
+	mask  int64            // Used for modulo calculations in indexes.
+	mngr  *SequenceManager // The Leader and Follower tracking the slots.
+}
+
+// RingBufferNew is a factory function that returns a new RingBuffer of size slots.
+// size should be a power of two.
+func RingBufferNew[T any](size int64) *RingBuffer[T] {
+	r := &RingBuffer[T]{
+		slots: make([]T, size),
+		mngr:  SequenceManagerNew(size),
+	}
+	r.mask = r.mngr.Leader.Mask()
+	return r
+}
+
+// Publish waits on the next free slot, passes it to fill and then commits it to the consumer.
+func (r *RingBuffer[T]) Publish(fill func(slot *T)) {
+	indx := r.mngr.Leader.Reserve()
+	fill(&r.slots[indx&r.mask])
+	r.mngr.Leader.Commit(indx)
+}
+
+// PublishBatch waits on the next count free slots, passes each to fill along with its sequence
+// number and then commits the batch to the consumer. count should not exceed the ring size.
+func (r *RingBuffer[T]) PublishBatch(count int64, fill func(seq int64, slot *T)) {
+	lower, upper := reserveBatch(r.mngr.Leader, count)
+	for i := lower; i <= upper; i++ {
+		fill(i, &r.slots[i&r.mask])
+	}
+	for i := lower; i <= upper; i++ {
+		r.mngr.Leader.Commit(i)
+	}
+}
+
+// Consume waits on the next published slot, passes it to process and then frees it for reuse.
+func (r *RingBuffer[T]) Consume(process func(slot *T)) {
+	indx := r.mngr.Follower.Reserve()
+	process(&r.slots[indx&r.mask])
+	r.mngr.Follower.Commit(indx)
+}
+
+// ConsumeBatch waits on the next count published slots, passes each to process along with its
+// sequence number and then frees the batch for reuse. count should not exceed the ring size.
+func (r *RingBuffer[T]) ConsumeBatch(count int64, process func(seq int64, slot *T)) {
+	lower, upper := reserveBatch(r.mngr.Follower, count)
+	for i := lower; i <= upper; i++ {
+		process(i, &r.slots[i&r.mask])
+	}
+	for i := lower; i <= upper; i++ {
+		r.mngr.Follower.Commit(i)
+	}
+}
+
+// Size returns the number of slots in the ring.
+func (r *RingBuffer[T]) Size() int64 {
+	return int64(len(r.slots))
+}
+
+// reserveBatch reserves count consecutive cells from s and returns the lower and upper bounds.
+func reserveBatch(s *SeqSimple, count int64) (int64, int64) {
+	lower := s.Reserve()
+	upper := lower
+	for upper-lower+1 < count {
+		upper = s.Reserve()
+	}
+	return lower, upper
+}
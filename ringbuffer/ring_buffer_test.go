@@ -0,0 +1,62 @@
+package ringbuffer
+
+import "testing"
+
+type testWork struct {
+	id    int64
+	value int
+}
+
+func TestRingBufferPublishConsume(t *testing.T) {
+	r := RingBufferNew[testWork](16)
+	if r.Size() != 16 {
+		t.Fatalf("RingBuffer size incorrect.\n\nExpected: 16\n\nActual: %d\n", r.Size())
+	}
+
+	total := 1000
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < total; i++ {
+			r.Consume(func(w *testWork) {
+				if w.value != i {
+					t.Errorf("Consumed out of order.\n\nExpected: %d\n\nActual: %d\n", i, w.value)
+				}
+			})
+		}
+	}()
+
+	for i := 0; i < total; i++ {
+		r.Publish(func(w *testWork) {
+			w.value = i
+		})
+	}
+	<-done
+}
+
+func TestRingBufferBatch(t *testing.T) {
+	r := RingBufferNew[testWork](64)
+	batch := int64(8)
+	rounds := 100
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		next := int64(0)
+		for i := 0; i < rounds; i++ {
+			r.ConsumeBatch(batch, func(seq int64, w *testWork) {
+				if seq != next || w.id != next {
+					t.Errorf("Batch consumed out of order.\n\nExpected: %d\n\nActual: %d %d\n",
+						next, seq, w.id)
+				}
+				next++
+			})
+		}
+	}()
+
+	for i := 0; i < rounds; i++ {
+		r.PublishBatch(batch, func(seq int64, w *testWork) {
+			w.id = seq
+		})
+	}
+	<-done
+}
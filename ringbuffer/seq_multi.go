@@ -1,84 +1,177 @@
 package ringbuffer
 
 import (
-	"math"
-	"runtime"
+	"context"
 	"sync/atomic"
+	"time"
 )
 
 // SeqMulti is used by multiple thread/go routines for tracking a Ring Buffer.
 type SeqMulti struct {
-	cursor     *int64    // Seq number and pointer to the next available pub/con slot.
-	dependency *SeqMulti // Another sequence committed buffer that we are waiting on finishing work.
-	leader     bool      // Is the sequence a follower (or a leader?
-	buffSize   int64     // The length of the ringbuffer and the committed map.
-	committed  []int32   // Tracks the commit states of the work being performed.
-	barrier    int64     // Used to calculate downstream or upstream dependencies.
-	mask       int64     // Used for modulo calculations in indexes.
-	shift      uint8     // Used for marking commit states in assignments to commited.
+	cursor       *int64       // Seq number and pointer to the next available pub/con slot.
+	dependencies []*SeqMulti  // Other sequence committed buffers that we are waiting on finishing work.
+	dependents   []*SeqMulti  // Other sequences waiting on our committed buffer, to be signalled.
+	leader       bool         // Is the sequence a follower (or a leader?
+	buffSize     int64        // The length of the ringbuffer and the committed map.
+	committed    []int64      // The last sequence committed in each cell.
+	stride       int64        // Positions between cells in committed, set by the layout.
+	barrier      int64        // Used to calculate downstream or upstream dependencies.
+	mask         int64        // Used for modulo calculations in indexes.
+	wait         WaitStrategy // How Reserve() waits on the dependency.
 }
 
 // Factory function for returning a new instance of a SeqMulti.
 func SeqMultiNew(size int64, dep *SeqMulti, leader bool) *SeqMulti {
 	s := &SeqMulti{
-		cursor:     new(int64),
-		leader:     leader,
-		dependency: dep,
-		committed:  make([]int32, size),
-		buffSize:   size,
-		mask:       size - 1,
-		shift:      uint8(math.Log2(float64(size))),
+		leader:   leader,
+		buffSize: size,
+		mask:     size - 1,
+		wait:     YieldingWaitNew(),
 	}
 
-	// Init the cursor and barrier adjustment with values.
-	*s.cursor = SequenceDefault
+	// Init the barrier adjustment, cursor and commit map.
 	if leader {
 		s.barrier = size
 	}
-
-	// Initialize buffer.
-	for i := int64(0); i < size; i++ {
-		s.committed[i] = int32(SequenceDefault)
+	s.SetLayout(LayoutDefault)
+	if dep != nil {
+		s.SetDependency(dep)
 	}
 	return s
 }
 
 // Reserve returns the upper most index for a segment of cells requested by "size".
+// count may vary between calls, but should not exceed the ring size.
 func (s *SeqMulti) Reserve(count int64) int64 {
+	upper, _ := s.reserve(context.Background(), count, true)
+	return upper
+}
+
+// ReserveContext is Reserve, but gives up and returns the context error once ctx is done.
+func (s *SeqMulti) ReserveContext(ctx context.Context, count int64) (int64, error) {
+	return s.reserve(ctx, count, true)
+}
+
+// TryReserve is Reserve, but returns ErrUnavailable rather than wait on the dependency.
+func (s *SeqMulti) TryReserve(count int64) (int64, error) {
+	return s.reserve(context.Background(), count, false)
+}
+
+// ReserveTimeout is Reserve, but returns ErrUnavailable if the dependency hasn't freed
+// the cells within d.
+func (s *SeqMulti) ReserveTimeout(count int64, d time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	upper, err := s.reserve(ctx, count, true)
+	if err == context.DeadlineExceeded {
+		err = ErrUnavailable
+	}
+	return upper, err
+}
+
+// reserve is the allocation loop behind the Reserve variants.
+func (s *SeqMulti) reserve(ctx context.Context, count int64, wait bool) (int64, error) {
 	// Loop and allocate
 	for {
 		previous := atomic.LoadInt64(s.cursor) // Get the previous pointer.
 		upper := previous + count              // Increment it to get the upper bounds of the chunk.
 		lower := previous + 1
-		gate := lower - s.barrier // Calculate the dependency barrier
 
-		// Check dependency based on first cell in the series.  If has not been processed
-		// in the last rotation, wait
-		for s.dependency.committed[lower&s.mask] != int32(gate>>s.shift) {
-			runtime.Gosched()
+		// Check dependency on every cell in the series, so go routines may reserve different
+		// batch sizes.  If has not been processed in the last rotation, wait. Another go routine
+		// may claim the cells while we wait, in which case we start over from the new cursor.
+		if !s.available(lower, upper) {
+			if !wait {
+				if atomic.LoadInt64(s.cursor) != previous {
+					continue
+				}
+				return SequenceDefault, ErrUnavailable
+			}
+			if err := s.waitFor(ctx, lower, upper, previous); err != nil {
+				return SequenceDefault, err
+			}
+			if atomic.LoadInt64(s.cursor) != previous {
+				continue
+			}
 		}
 
 		// Update the new sequence number
 		if atomic.CompareAndSwapInt64(s.cursor, previous, upper) {
-			return upper
+			return upper, nil
+		}
+	}
+}
+
+// waitFor waits until the dependency has committed the cells lower through upper, or the cursor
+// has moved on from previous.
+func (s *SeqMulti) waitFor(ctx context.Context, lower, upper, previous int64) error {
+	return s.wait.WaitFor(ctx, func() bool {
+		return s.available(lower, upper) || atomic.LoadInt64(s.cursor) != previous
+	})
+}
+
+// available returns whether every dependency has committed the cells lower through upper. The
+// barrier moves the sequence a leader waits on back a rotation.
+func (s *SeqMulti) available(lower, upper int64) bool {
+	for _, d := range s.dependencies {
+		for i := lower; i <= upper; i++ {
+			if atomic.LoadInt64(d.cell(i)) != i-s.barrier {
+				return false
+			}
 		}
 	}
+	return true
 }
 
 // Commit updates the committed map to track that a segment in the ring buffer
 // has been allocated and used.
 func (s *SeqMulti) Commit(lower, upper int64) {
 	for ; upper >= lower; upper-- {
-		s.committed[upper&s.mask] = int32(upper >> s.shift)
+		atomic.StoreInt64(s.cell(upper), upper)
 	}
+	for _, d := range s.dependents {
+		d.wait.Signal()
+	}
+}
+
+// cell returns the commit map entry of the cell at index.
+func (s *SeqMulti) cell(index int64) *int64 {
+	return &s.committed[(index&s.mask)*s.stride]
 }
 
-// SetDependency is a setter for the dependency of this sequence.
-func (s *SeqMulti) SetDependency(d *SeqMulti) {
-	s.dependency = d
+// SetDependency is a setter for the dependencies of this sequence. Reserve() waits until all
+// of them have committed a cell. It should only be called while wiring up the ring.
+func (s *SeqMulti) SetDependency(deps ...*SeqMulti) {
+	s.dependencies = deps
+	for _, d := range deps {
+		d.dependents = append(d.dependents, s)
+	}
+}
+
+// SetLayout is a setter for how the cursor and commit map are placed in memory. It resets the
+// sequence, so should only be called while wiring up the ring, and the ring should be no larger
+// than l.SizeMax().
+func (s *SeqMulti) SetLayout(l Layout) {
+	s.cursor, s.committed, s.stride = layoutAlloc(l, s.buffSize)
+	*s.cursor = SequenceDefault
+
+	// Initialize buffer as if the rotation before the first was committed.
+	for i := int64(0); i < s.buffSize; i++ {
+		s.committed[i*s.stride] = i - s.buffSize
+	}
+}
+
+// SetWaitStrategy is a setter for how this sequence waits on its dependency.
+func (s *SeqMulti) SetWaitStrategy(w WaitStrategy) {
+	s.wait = w
 }
 
 // Mask is a getter for the index mask.
 func (s *SeqMulti) Mask() int64 {
 	return s.mask
 }
+
+// Cursor is a getter for the highest index reserved so far.
+func (s *SeqMulti) Cursor() int64 {
+	return atomic.LoadInt64(s.cursor)
+}
@@ -1,61 +1,113 @@
 package ringbuffer
 
 import (
-	"math"
-	"runtime"
+	"context"
+	"sync/atomic"
+	"time"
 )
 
 // SeqSimple is a hub for a single thread/go routine to track access to a ring buffer.
 type SeqSimple struct {
-	cursor     *int64     // Seq number and pointer to the next available pub/con slot.
-	dependency *SeqSimple // Another sequence committed buffer that we are waiting on finishing work.
-	leader     bool       // Is the sequence a follower (or a leader?
-	buffSize   int64      // The length of the ringbuffer and the committed map.
-	committed  []int32    // Tracks the commit states of the work being performed.
-	barrier    int64      // Used to calculate downstream or upstream dependencies.
-	mask       int64      // Used for modulo calculations in indexes.
-	shift      uint8      // Used for marking commit states in assignments to commited.
+	cursor     *int64       // Seq number and pointer to the next available pub/con slot.
+	dependency *SeqSimple   // Another sequence committed buffer that we are waiting on finishing work.
+	leader     bool         // Is the sequence a follower (or a leader?
+	buffSize   int64        // The length of the ringbuffer and the committed map.
+	committed  []int64      // The last sequence committed in each cell.
+	stride     int64        // Positions between cells in committed, set by the layout.
+	barrier    int64        // Used to calculate downstream or upstream dependencies.
+	mask       int64        // Used for modulo calculations in indexes.
+	wait       WaitStrategy // How Reserve() waits on the dependency.
 }
 
 // Factory function for returning a new instance of a SeqSimple.
 func SeqSimpleNew(size int64, dep *SeqSimple, leader bool) *SeqSimple {
 	s := &SeqSimple{
-		cursor:     new(int64),
 		leader:     leader,
 		dependency: dep,
-		committed:  make([]int32, size),
 		buffSize:   size,
 		mask:       size - 1,
-		shift:      uint8(math.Log2(float64(size))),
+		wait:       YieldingWaitNew(),
 	}
-
-	// Init the cursor and commit map with default values.
-	*s.cursor = SequenceDefault
 	if leader {
 		s.barrier = size
 	}
+	s.SetLayout(LayoutDefault)
+	return s
+}
+
+// SetLayout is a setter for how the cursor and commit map are placed in memory. It resets the
+// sequence, so should only be called while wiring up the ring, and the ring should be no larger
+// than l.SizeMax().
+func (s *SeqSimple) SetLayout(l Layout) {
+	s.cursor, s.committed, s.stride = layoutAlloc(l, s.buffSize)
+	*s.cursor = SequenceDefault
 
-	// Initialize buffer.
-	for i := int64(0); i < size; i++ {
-		s.committed[i] = int32(SequenceDefault)
+	// Initialize buffer as if the rotation before the first was committed.
+	for i := int64(0); i < s.buffSize; i++ {
+		s.committed[i*s.stride] = i - s.buffSize
 	}
-	return s
 }
 
 // Reserve incrmenets and returns the upper most index for a cell to fill or read.
 func (s *SeqSimple) Reserve() int64 {
-	*s.cursor += 1
-	gate := *s.cursor - s.barrier
-	for s.dependency.committed[*s.cursor&s.mask] != int32(gate>>s.shift) { // validate dependency block
-		runtime.Gosched()
+	index, _ := s.reserve(context.Background(), true)
+	return index
+}
+
+// ReserveContext is Reserve, but gives up and returns the context error once ctx is done.
+func (s *SeqSimple) ReserveContext(ctx context.Context) (int64, error) {
+	return s.reserve(ctx, true)
+}
+
+// TryReserve is Reserve, but returns ErrUnavailable rather than wait on the dependency.
+func (s *SeqSimple) TryReserve() (int64, error) {
+	return s.reserve(context.Background(), false)
+}
+
+// ReserveTimeout is Reserve, but returns ErrUnavailable if the dependency hasn't freed
+// the cell within d.
+func (s *SeqSimple) ReserveTimeout(d time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	index, err := s.reserve(ctx, true)
+	if err == context.DeadlineExceeded {
+		err = ErrUnavailable
+	}
+	return index, err
+}
+
+// reserve checks the next cell is free and moves the cursor onto it. The cursor is left
+// alone if we give up.
+func (s *SeqSimple) reserve(ctx context.Context, wait bool) (int64, error) {
+	index := *s.cursor + 1
+	if !s.available(index) { // validate dependency block
+		if !wait {
+			return SequenceDefault, ErrUnavailable
+		}
+		if err := s.wait.WaitFor(ctx, func() bool { return s.available(index) }); err != nil {
+			return SequenceDefault, err
+		}
 	}
-	return *s.cursor
+	*s.cursor = index
+	return index, nil
+}
+
+// available returns whether the dependency has committed the cell at index. The barrier moves
+// the sequence a leader waits on back a rotation.
+func (s *SeqSimple) available(index int64) bool {
+	return atomic.LoadInt64(s.dependency.cell(index)) == index-s.barrier
 }
 
 // Commit updates the committed map to track that a segment in the ring buffer
 // has been allocated and used.
 func (s *SeqSimple) Commit(index int64) {
-	s.committed[index&s.mask] = int32(index >> s.shift)
+	atomic.StoreInt64(s.cell(index), index)
+	s.dependency.wait.Signal()
+}
+
+// cell returns the commit map entry of the cell at index.
+func (s *SeqSimple) cell(index int64) *int64 {
+	return &s.committed[(index&s.mask)*s.stride]
 }
 
 // SetDependency is a setter for the dependency of this sequence.
@@ -63,6 +115,11 @@ func (s *SeqSimple) SetDependency(d *SeqSimple) {
 	s.dependency = d
 }
 
+// SetWaitStrategy is a setter for how this sequence waits on its dependency.
+func (s *SeqSimple) SetWaitStrategy(w WaitStrategy) {
+	s.wait = w
+}
+
 // Mask is a getter for the index mask.
 func (s *SeqSimple) Mask() int64 {
 	return s.mask
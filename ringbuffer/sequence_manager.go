@@ -18,3 +18,10 @@ func SequenceManagerNew(size int64) *SequenceManager {
 	m.Follower.SetDependency(m.Leader)
 	return m
 }
+
+// SetLayout sets how the Leader and Follower are placed in memory. It resets both, so should
+// only be called before the ring is used.
+func (m *SequenceManager) SetLayout(l Layout) {
+	m.Leader.SetLayout(l)
+	m.Follower.SetLayout(l)
+}
@@ -1,6 +1,10 @@
 package ringbuffer
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+)
 
 func TestReserveLeader(t *testing.T) {
 	m := SequenceManagerNew(1024)
@@ -13,7 +17,7 @@ func TestReserveLeader(t *testing.T) {
 
 	// Pretend we did the work in the Consumer.
 	for i := 0; i < 8; i++ {
-		m.Follower.committed[i] = 0
+		*m.Follower.cell(int64(i)) = int64(i)
 	}
 
 	// Now rotate past beginning again w/ more jobs up to the last cell.
@@ -71,3 +75,109 @@ func TestLoadSimple(t *testing.T) {
 	}
 	<-done
 }
+
+func TestReserveUnavailable(t *testing.T) {
+	m := SequenceManagerNew(4)
+
+	// Nothing published so the Follower has nothing to read.
+	if _, err := m.Follower.TryReserve(); err != ErrUnavailable {
+		t.Fatalf("Expected ErrUnavailable from an empty ring, received %v.", err)
+	}
+
+	// Fill the ring so the Leader has nowhere to write.
+	for i := 0; i < 4; i++ {
+		j := m.Leader.Reserve()
+		m.Leader.Commit(j)
+	}
+	if _, err := m.Leader.TryReserve(); err != ErrUnavailable {
+		t.Fatalf("Expected ErrUnavailable from a full ring, received %v.", err)
+	}
+	if _, err := m.Leader.ReserveTimeout(10 * time.Millisecond); err != ErrUnavailable {
+		t.Fatalf("Expected ErrUnavailable after timeout, received %v.", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	if _, err := m.Leader.ReserveContext(ctx); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, received %v.", err)
+	}
+
+	// A failed reserve must not move the cursor.
+	j := m.Follower.Reserve()
+	m.Follower.Commit(j)
+	if j, err := m.Leader.TryReserve(); err != nil || j != 4 {
+		t.Fatalf("Expected to reserve cell 4, received %d %v.", j, err)
+	}
+}
+
+// testOverflowPoints returns sequences to fast-forward a ring of size to, either side of where
+// a 32 bit count of rotations would wrap.
+func testOverflowPoints(size int64) []int64 {
+	return []int64{
+		size<<31 - 2*size, // Rotations pass math.MaxInt32.
+		size<<32 - 2*size, // Rotations pass math.MaxUint32 and repeat the initial -1.
+		1 << 62,
+	}
+}
+
+// testFastForwardSimple moves the sequences on to next, as if all work before it has passed
+// through the ring.
+func testFastForwardSimple(next int64, seqs ...*SeqSimple) {
+	for _, s := range seqs {
+		*s.cursor = next - 1
+		for c := int64(0); c < s.buffSize; c++ {
+			*s.cell(next + c) = next + c - s.buffSize
+		}
+	}
+}
+
+func TestReserveOverflow(t *testing.T) {
+	for _, size := range []int64{2, 8, 1024} {
+		for _, next := range testOverflowPoints(size) {
+			m := SequenceManagerNew(size)
+			testFastForwardSimple(next, m.Leader, m.Follower)
+
+			// Run a few rotations through the ring, checking the order of the work. A follower
+			// failing cancels the leader, rather than leaving it waiting on a full ring.
+			ring := make([]int64, size)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan bool)
+			go func() {
+				defer close(done)
+				for i := next; i < next+size*4; i++ {
+					j := m.Follower.Reserve()
+					if j != i || ring[j&m.Follower.Mask()] != i {
+						t.Errorf("Ring %d at %d: follower read %d from cell %d.", size, i, ring[j&m.Follower.Mask()], j)
+						cancel()
+						return
+					}
+					m.Follower.Commit(j)
+				}
+			}()
+			for i := next; i < next+size*4; i++ {
+				j, err := m.Leader.ReserveContext(ctx)
+				if err != nil {
+					break
+				}
+				ring[j&m.Leader.Mask()] = j
+				m.Leader.Commit(j)
+			}
+			<-done
+			cancel()
+			if t.Failed() {
+				return
+			}
+
+			// The ring is empty, so the follower must wait and the leader may fill it once only.
+			if _, err := m.Follower.TryReserve(); err != ErrUnavailable {
+				t.Fatalf("Ring %d at %d: follower read past the leader, %v.", size, next, err)
+			}
+			for i := int64(0); i < size; i++ {
+				j := m.Leader.Reserve()
+				m.Leader.Commit(j)
+			}
+			if _, err := m.Leader.TryReserve(); err != ErrUnavailable {
+				t.Fatalf("Ring %d at %d: leader overwrote unread work, %v.", size, next, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// WaitStrategy determines how a sequence waits on its dependency to commit a cell.
+type WaitStrategy interface {
+	WaitFor(ctx context.Context, ready func() bool) error // Returns once ready() or ctx is done.
+	Signal()                                              // Wakes waiters after a commit.
+}
+
+// WaitStrategyNew is a factory function that returns a new WaitStrategy by name.
+func WaitStrategyNew(name string) (WaitStrategy, error) {
+	switch name {
+	case WaitBusySpin:
+		return BusySpinWaitNew(), nil
+	case WaitYielding, "":
+		return YieldingWaitNew(), nil
+	case WaitSleeping:
+		return SleepingWaitNew(), nil
+	case WaitBlocking:
+		return BlockingWaitNew(), nil
+	}
+	return nil, fmt.Errorf("Unknown wait strategy %q.", name)
+}
+
+// BusySpinWait polls the dependency in a tight loop. Lowest latency, but it keeps a core busy
+// while the ring is idle, and should only be used with a spare core for every waiting go routine.
+type BusySpinWait struct{}
+
+// BusySpinWaitNew is a factory function that returns a new BusySpinWait.
+func BusySpinWaitNew() *BusySpinWait {
+	return &BusySpinWait{}
+}
+
+// WaitFor spins until ready.
+func (w *BusySpinWait) WaitFor(ctx context.Context, ready func() bool) error {
+	done := ctx.Done()
+	for !ready() {
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
+		}
+	}
+	return nil
+}
+
+// Signal is a no-op as nothing sleeps.
+func (w *BusySpinWait) Signal() {}
+
+// YieldingWait polls the dependency and yields the processor to other go routines between
+// polls. This is the default.
+type YieldingWait struct{}
+
+// YieldingWaitNew is a factory function that returns a new YieldingWait.
+func YieldingWaitNew() *YieldingWait {
+	return &YieldingWait{}
+}
+
+// WaitFor yields until ready.
+func (w *YieldingWait) WaitFor(ctx context.Context, ready func() bool) error {
+	done := ctx.Done()
+	for !ready() {
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
+			runtime.Gosched()
+		}
+	}
+	return nil
+}
+
+// Signal is a no-op as nothing sleeps.
+func (w *YieldingWait) Signal() {}
+
+// SleepingWait spins, then yields, then sleeps with an exponential backoff. It trades wake up
+// latency for near idle processor usage when the ring is quiet.
+type SleepingWait struct {
+	spins  int           // Polls before yielding.
+	yields int           // Yields before sleeping.
+	min    time.Duration // First sleep period.
+	max    time.Duration // Longest sleep period.
+}
+
+// SleepingWaitNew is a factory function that returns a new SleepingWait.
+func SleepingWaitNew() *SleepingWait {
+	return &SleepingWait{
+		spins:  sleepingWaitSpins,
+		yields: sleepingWaitYields,
+		min:    sleepingWaitMin,
+		max:    sleepingWaitMax,
+	}
+}
+
+// WaitFor backs off until ready.
+func (w *SleepingWait) WaitFor(ctx context.Context, ready func() bool) error {
+	done := ctx.Done()
+	sleep := w.min
+	for i := 0; !ready(); i++ {
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
+		}
+		switch {
+		case i < w.spins:
+		case i < w.spins+w.yields:
+			runtime.Gosched()
+		default:
+			time.Sleep(sleep)
+			if sleep *= 2; sleep > w.max {
+				sleep = w.max
+			}
+		}
+	}
+	return nil
+}
+
+// Signal is a no-op as sleepers wake up on their own.
+func (w *SleepingWait) Signal() {}
+
+// BlockingWait parks waiting go routines on a channel until the dependency signals a commit.
+// It uses no processor while idle, at the cost of a lock on every commit.
+type BlockingWait struct {
+	mu sync.Mutex    // For locking access to the channel.
+	ch chan struct{} // Closed on Signal() to wake all waiters.
+}
+
+// BlockingWaitNew is a factory function that returns a new BlockingWait.
+func BlockingWaitNew() *BlockingWait {
+	return &BlockingWait{}
+}
+
+// WaitFor blocks until ready.
+func (w *BlockingWait) WaitFor(ctx context.Context, ready func() bool) error {
+	for {
+		// Take the channel before checking, so a commit after the check always wakes us.
+		w.mu.Lock()
+		if w.ch == nil {
+			w.ch = make(chan struct{})
+		}
+		ch := w.ch
+		w.mu.Unlock()
+
+		if ready() {
+			return nil
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Signal wakes all waiters.
+func (w *BlockingWait) Signal() {
+	w.mu.Lock()
+	if w.ch != nil {
+		close(w.ch)
+		w.ch = nil
+	}
+	w.mu.Unlock()
+}
@@ -0,0 +1,74 @@
+package ringbuffer
+
+import (
+	"sync"
+	"testing"
+)
+
+var testWaitStrategies = []string{WaitBusySpin, WaitYielding, WaitSleeping, WaitBlocking}
+
+func TestWaitStrategyNew(t *testing.T) {
+	t.Parallel()
+	for _, name := range testWaitStrategies {
+		if _, err := WaitStrategyNew(name); err != nil {
+			t.Errorf("Wait strategy %s not created: %s", name, err)
+		}
+	}
+	if _, err := WaitStrategyNew("bogus"); err == nil {
+		t.Errorf("Unknown wait strategy should return an error.")
+	}
+}
+
+func TestWaitStrategySimple(t *testing.T) {
+	for _, name := range testWaitStrategies {
+		m := SequenceManagerNew(64)
+		lw, _ := WaitStrategyNew(name)
+		fw, _ := WaitStrategyNew(name)
+		m.Leader.SetWaitStrategy(lw)
+		m.Follower.SetWaitStrategy(fw)
+
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < 1000; i++ {
+				j := m.Follower.Reserve()
+				m.Follower.Commit(j)
+			}
+			close(done)
+		}()
+		for i := 0; i < 1000; i++ {
+			j := m.Leader.Reserve()
+			m.Leader.Commit(j)
+		}
+		<-done
+	}
+}
+
+func TestWaitStrategyMulti(t *testing.T) {
+	for _, name := range testWaitStrategies {
+		m := ManagerNew(64)
+		lw, _ := WaitStrategyNew(name)
+		fw, _ := WaitStrategyNew(name)
+		m.Leader.SetWaitStrategy(lw)
+		m.Follower.SetWaitStrategy(fw)
+
+		var wg sync.WaitGroup
+		for c := 0; c < 4; c++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 250; i++ {
+					j := m.Follower.Reserve(1)
+					m.Follower.Commit(j, j)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 250; i++ {
+					j := m.Leader.Reserve(1)
+					m.Leader.Commit(j, j)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
@@ -3,8 +3,11 @@ package main
 
 import (
 	"flag"
+	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/composer22/ringoexp/server"
 )
@@ -13,39 +16,20 @@ var (
 	log *server.RingoExpLogger = server.RingoExpLoggerNew()
 )
 
+const benchDefaultURL = "ws://localhost:6660/v1.0/ingest" // Default server for the bench subcommand.
+
 // main is the main entry point for the application or server launch.
 func main() {
-	opts := server.Options{}
-	var showVersion bool
+	// The bench subcommand load tests a running server instead.
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(benchMain(os.Args[2:]))
+	}
 
-	flag.StringVar(&opts.Name, "N", "", "Name of the server.")
-	flag.StringVar(&opts.Name, "--name", "", "Name of the server.")
-	flag.StringVar(&opts.Hostname, "H", server.DefaultHostname, "Hostname of the server.")
-	flag.StringVar(&opts.Hostname, "--hostname", server.DefaultHostname, "Hostname of the server.")
-	flag.IntVar(&opts.Port, "p", server.DefaultPort, "Port to listen on.")
-	flag.IntVar(&opts.Port, "--port", server.DefaultPort, "Port to listen on.")
-	flag.IntVar(&opts.MaxConns, "n", server.DefaultMaxConns, "Maximum incoming connections allowed (s + http).")
-	flag.IntVar(&opts.MaxConns, "--connections", server.DefaultMaxConns, "Maximum incoming connections allowed (ws + http).")
-	flag.BoolVar(&opts.IsPublisher, "I", server.DefaultIsPublisher, "Is the server a publisher (true) or a consumer?")
-	flag.BoolVar(&opts.IsPublisher, "--is_publisher", server.DefaultIsPublisher, "Is the server a publisher (true) or a consumer?")
-	flag.IntVar(&opts.RingSize, "r", server.DefaultRingSize, "Maximum ringbuffer size if publisher.")
-	flag.IntVar(&opts.RingSize, "--ring_size", server.DefaultRingSize, "Maximum ringbuffer size if publisher.")
-	flag.StringVar(&opts.ConsumerHostname, "U", server.DefaultConsumerHostname, "Hostname of the remote consumer server.")
-	flag.StringVar(&opts.ConsumerHostname, "--consumer_hostname", server.DefaultConsumerHostname, "Hostname of the remote consumer server.")
-	flag.IntVar(&opts.ConsumerPort, "T", server.DefaultConsumerPort, "Port of the remote consumer server.")
-	flag.IntVar(&opts.ConsumerPort, "--consumer_port", server.DefaultConsumerPort, "Port of the remote consumer server.")
-	flag.IntVar(&opts.MaxWorkers, "W", server.DefaultMaxWorkers, "Maximum outgoing worker connections allowed if publisher.")
-	flag.IntVar(&opts.MaxWorkers, "--workers", server.DefaultMaxWorkers, "Maximum outgoing worker connections allowed if publisher.")
-	flag.IntVar(&opts.MaxProcs, "X", server.DefaultMaxProcs, "Maximum processor cores to use.")
-	flag.IntVar(&opts.MaxProcs, "--procs", server.DefaultMaxProcs, "Maximum processor cores to use.")
-	flag.IntVar(&opts.ProfPort, "L", server.DefaultProfPort, "Profiler port to listen on.")
-	flag.IntVar(&opts.ProfPort, "--profiler_port", server.DefaultProfPort, "Profiler port to listen on.")
-	flag.BoolVar(&opts.Debug, "d", false, "Enable debugging output.")
-	flag.BoolVar(&opts.Debug, "--debug", false, "Enable debugging output.")
-	flag.BoolVar(&showVersion, "V", false, "Show version.")
-	flag.BoolVar(&showVersion, "--version", false, "Show version.")
-	flag.Usage = server.PrintUsageAndExit
-	flag.Parse()
+	opts := &server.Options{}
+	var showVersion bool
+	var configFile string
+	fs := optionsFlagSet(opts, &configFile, &showVersion)
+	fs.Parse(os.Args[1:])
 
 	// Version flag request?
 	if showVersion {
@@ -53,7 +37,7 @@ func main() {
 	}
 
 	// Check additional params beyond the flags.
-	for _, arg := range flag.Args() {
+	for _, arg := range fs.Args() {
 		switch strings.ToLower(arg) {
 		case "version":
 			server.PrintVersionAndExit()
@@ -62,12 +46,132 @@ func main() {
 		}
 	}
 
+	// Layer the options: flags > environment > config file > defaults.
+	if err := loadOptions(fs, opts, configFile); err != nil {
+		log.Errorf("%s", err.Error())
+		os.Exit(1)
+	}
+	if err := opts.Validate(); err != nil {
+		log.Errorf("%s", err.Error())
+		os.Exit(1)
+	}
+
 	// Set thread and proc usage.
 	if opts.MaxProcs > 0 {
 		runtime.GOMAXPROCS(opts.MaxProcs)
 	}
 	log.Infof("NumCPU %d GOMAXPROCS: %d\n", runtime.NumCPU(), runtime.GOMAXPROCS(-1))
 
-	s := server.New(&opts)
-	s.Start()
+	s := server.New(opts)
+	s.SetReloader(reloadOptions)
+	handleSignals(s)
+	if err := s.Start(); err != nil {
+		os.Exit(1)
+	}
+	log.Infof("Server exiting.")
+}
+
+// handleSignals responds to operating system signals: interrupts and terminations shut the
+// server down gracefully, after which Start returns, and hangups reload its options.
+func handleSignals(s *server.Server) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range c {
+			log.Infof("Server received signal: %v\n", sig)
+			if sig == syscall.SIGHUP {
+				s.Reload()
+				continue
+			}
+			signal.Stop(c)
+			s.Shutdown()
+			return
+		}
+	}()
+}
+
+// optionsFlagSet returns the command line flags of the server, set to fill in opts.
+func optionsFlagSet(opts *server.Options, configFile *string, showVersion *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&opts.Name, "N", "", "Name of the server.")
+	fs.StringVar(&opts.Name, "name", "", "Name of the server.")
+	fs.StringVar(&opts.Hostname, "H", server.DefaultHostname, "Hostname of the server.")
+	fs.StringVar(&opts.Hostname, "hostname", server.DefaultHostname, "Hostname of the server.")
+	fs.IntVar(&opts.Port, "p", server.DefaultPort, "Port to listen on.")
+	fs.IntVar(&opts.Port, "port", server.DefaultPort, "Port to listen on.")
+	fs.IntVar(&opts.MaxConns, "n", server.DefaultMaxConns, "Maximum incoming connections allowed (s + http).")
+	fs.IntVar(&opts.MaxConns, "connections", server.DefaultMaxConns, "Maximum incoming connections allowed (ws + http).")
+	fs.BoolVar(&opts.IsPublisher, "I", server.DefaultIsPublisher, "Is the server a publisher (true) or a consumer?")
+	fs.BoolVar(&opts.IsPublisher, "is_publisher", server.DefaultIsPublisher, "Is the server a publisher (true) or a consumer?")
+	fs.IntVar(&opts.RingSize, "r", server.DefaultRingSize, "Maximum ringbuffer size if publisher.")
+	fs.IntVar(&opts.RingSize, "ring_size", server.DefaultRingSize, "Maximum ringbuffer size if publisher.")
+	fs.StringVar(&opts.ConsumerHostname, "U", server.DefaultConsumerHostname, "Hostname of the remote consumer server.")
+	fs.StringVar(&opts.ConsumerHostname, "consumer_hostname", server.DefaultConsumerHostname, "Hostname of the remote consumer server.")
+	fs.IntVar(&opts.ConsumerPort, "T", server.DefaultConsumerPort, "Port of the remote consumer server.")
+	fs.IntVar(&opts.ConsumerPort, "consumer_port", server.DefaultConsumerPort, "Port of the remote consumer server.")
+	fs.IntVar(&opts.MaxWorkers, "W", server.DefaultMaxWorkers, "Maximum outgoing worker connections allowed if publisher.")
+	fs.IntVar(&opts.MaxWorkers, "workers", server.DefaultMaxWorkers, "Maximum outgoing worker connections allowed if publisher.")
+	fs.StringVar(&opts.WaitStrategy, "w", server.DefaultWaitStrategy, "How ring sequences wait on each other if publisher.")
+	fs.StringVar(&opts.WaitStrategy, "wait_strategy", server.DefaultWaitStrategy, "How ring sequences wait on each other if publisher.")
+	fs.IntVar(&opts.BusyWait, "B", server.DefaultBusyWait, "Milliseconds ingest waits on a full ring before replying busy if publisher.")
+	fs.IntVar(&opts.BusyWait, "busy_wait", server.DefaultBusyWait, "Milliseconds ingest waits on a full ring before replying busy if publisher.")
+	fs.IntVar(&opts.DrainTimeout, "D", server.DefaultDrainTimeout, "Seconds to forward work left in the ring on shutdown if publisher.")
+	fs.IntVar(&opts.DrainTimeout, "drain_timeout", server.DefaultDrainTimeout, "Seconds to forward work left in the ring on shutdown if publisher.")
+	fs.StringVar(&opts.JournalDir, "j", "", "Directory to journal the ring to if publisher.")
+	fs.StringVar(&opts.JournalDir, "journal_dir", "", "Directory to journal the ring to if publisher.")
+	fs.StringVar(&opts.Store, "s", server.DefaultStore, "Where work is stored (memory or file) if consumer.")
+	fs.StringVar(&opts.Store, "store", server.DefaultStore, "Where work is stored (memory or file) if consumer.")
+	fs.StringVar(&opts.StoreFile, "F", server.DefaultStoreFile, "File work is appended to if consumer and store is file.")
+	fs.StringVar(&opts.StoreFile, "store_file", server.DefaultStoreFile, "File work is appended to if consumer and store is file.")
+	fs.IntVar(&opts.StoreBatch, "b", server.DefaultStoreBatch, "Values buffered per write to the store if consumer.")
+	fs.IntVar(&opts.StoreBatch, "store_batch", server.DefaultStoreBatch, "Values buffered per write to the store if consumer.")
+	fs.IntVar(&opts.MaxProcs, "X", server.DefaultMaxProcs, "Maximum processor cores to use.")
+	fs.IntVar(&opts.MaxProcs, "procs", server.DefaultMaxProcs, "Maximum processor cores to use.")
+	fs.IntVar(&opts.ProfPort, "L", server.DefaultProfPort, "Profiler port to listen on.")
+	fs.IntVar(&opts.ProfPort, "profiler_port", server.DefaultProfPort, "Profiler port to listen on.")
+	fs.BoolVar(&opts.Debug, "d", false, "Enable debugging output.")
+	fs.BoolVar(&opts.Debug, "debug", false, "Enable debugging output.")
+	fs.StringVar(configFile, "c", "", "Config file (.json, .yaml or .toml) of options.")
+	fs.StringVar(configFile, "config", "", "Config file (.json, .yaml or .toml) of options.")
+	fs.BoolVar(showVersion, "V", false, "Show version.")
+	fs.BoolVar(showVersion, "version", false, "Show version.")
+	fs.Usage = server.PrintUsageAndExit
+	return fs
+}
+
+// reloadOptions loads the options afresh from the command line, environment and config file, as
+// on start, for a server reload.
+func reloadOptions() (*server.Options, error) {
+	opts := &server.Options{}
+	var showVersion bool
+	var configFile string
+	fs := optionsFlagSet(opts, &configFile, &showVersion)
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	if err := loadOptions(fs, opts, configFile); err != nil {
+		return nil, err
+	}
+	return opts, opts.Validate()
+}
+
+// loadOptions overlays the config file and environment onto the flag defaults in opts, then
+// puts back any flags given explicitly on the command line.
+func loadOptions(fs *flag.FlagSet, opts *server.Options, configFile string) error {
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+	if configFile != "" {
+		if err := opts.LoadFile(configFile); err != nil {
+			return err
+		}
+	}
+	if err := opts.LoadEnv(server.EnvPrefix); err != nil {
+		return err
+	}
+	for name, value := range set {
+		fs.Set(name, value)
+	}
+	return nil
 }
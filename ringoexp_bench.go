@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/composer22/ringoexp/bench"
+	"github.com/composer22/ringoexp/server"
+)
+
+// benchMain runs the bench subcommand, load testing a running server, and returns the exit code.
+func benchMain(args []string) int {
+	opts := bench.Options{}
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&opts.URL, "u", benchDefaultURL, "Ingest endpoint of the server to load.")
+	fs.StringVar(&opts.URL, "url", benchDefaultURL, "Ingest endpoint of the server to load.")
+	fs.IntVar(&opts.Connections, "n", 8, "Connections to open.")
+	fs.IntVar(&opts.Connections, "connections", 8, "Connections to open.")
+	fs.IntVar(&opts.Batch, "b", 1, "Values per frame.")
+	fs.IntVar(&opts.Batch, "batch", 1, "Values per frame.")
+	fs.IntVar(&opts.Window, "w", 64, "Frames in flight per connection.")
+	fs.IntVar(&opts.Window, "window", 64, "Frames in flight per connection.")
+	fs.IntVar(&opts.Rate, "R", 0, "Target values per second, 0 = as fast as possible.")
+	fs.IntVar(&opts.Rate, "rate", 0, "Target values per second, 0 = as fast as possible.")
+	fs.IntVar(&opts.Count, "m", 0, "Values to send, 0 = send for the duration.")
+	fs.IntVar(&opts.Count, "count", 0, "Values to send, 0 = send for the duration.")
+	fs.DurationVar(&opts.Duration, "d", 10*time.Second, "How long to send for.")
+	fs.DurationVar(&opts.Duration, "duration", 10*time.Second, "How long to send for.")
+	fs.Usage = server.PrintUsageAndExit
+	fs.Parse(args)
+
+	// Cntl-c stops sending early and still prints the summary.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Infof("Loading %s with %d connections...", opts.URL, opts.Connections)
+	r, err := bench.Run(ctx, opts)
+	if r != nil {
+		fmt.Print(r)
+	}
+	if err != nil {
+		log.Errorf("Bench failed: %s", err.Error())
+		return 1
+	}
+	return 0
+}
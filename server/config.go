@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFile sets the options found in a JSON, YAML or TOML config file, chosen by the file
+// extension. Keys are the json names of the options, such as "ringSize". Options missing from
+// the file are left unchanged.
+func (o *Options) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// YAML and TOML are converted to JSON so every format shares the json tags.
+	var m map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &m)
+	case ".toml":
+		_, err = toml.Decode(string(b), &m)
+	default:
+		return fmt.Errorf("Config file %s must be .json, .yaml, .yml or .toml.", path)
+	}
+	if err == nil && m != nil {
+		b, err = json.Marshal(m)
+	}
+	if err != nil {
+		return fmt.Errorf("Config file %s: %s", path, err.Error())
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err = d.Decode(o); err != nil {
+		return fmt.Errorf("Config file %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// LoadEnv sets the options found in environment variables. The name of each variable is prefix
+// followed by the json name of the option in upper snake case, such as RINGOEXP_RING_SIZE.
+func (o *Options) LoadEnv(prefix string) error {
+	v := reflect.ValueOf(o).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := prefix + envName(t.Field(i).Tag.Get("json"))
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(val)
+		case reflect.Int:
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("Environment %s=%q is not an integer.", name, val)
+			}
+			f.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("Environment %s=%q is not true or false.", name, val)
+			}
+			f.SetBool(b)
+		}
+	}
+	return nil
+}
+
+// envName converts a json option name such as "maxConns" to "MAX_CONNS".
+func envName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
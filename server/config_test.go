@@ -0,0 +1,67 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOptionsLoadFile(t *testing.T) {
+	t.Parallel()
+	files := map[string]string{
+		"ringoexp.json": `{"name":"Test Server","port":7001,"isPublisher":false,"ringSize":512}`,
+		"ringoexp.yaml": "name: Test Server\nport: 7001\nisPublisher: false\nringSize: 512\n",
+		"ringoexp.toml": "name = \"Test Server\"\nport = 7001\nisPublisher = false\nringSize = 512\n",
+	}
+	dir := t.TempDir()
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(body), 0644)
+		opts := &Options{Hostname: "localhost", IsPublisher: true, MaxWorkers: 3}
+		if err := opts.LoadFile(path); err != nil {
+			t.Errorf("Config %s not loaded: %s", name, err)
+			continue
+		}
+		if opts.Name != "Test Server" || opts.Port != 7001 || opts.IsPublisher || opts.RingSize != 512 {
+			t.Errorf("Config %s not applied. Received %s.", name, opts)
+		}
+		if opts.Hostname != "localhost" || opts.MaxWorkers != 3 {
+			t.Errorf("Config %s should leave missing options unchanged. Received %s.", name, opts)
+		}
+	}
+
+	bad := map[string]string{
+		"unknown.json": `{"prot":7001}`,
+		"type.yaml":    "port: seven\n",
+		"syntax.toml":  "port = \n",
+		"ringoexp.ini": "port=7001\n",
+	}
+	for name, body := range bad {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(body), 0644)
+		if err := (&Options{}).LoadFile(path); err == nil {
+			t.Errorf("Config %s should fail to load.", name)
+		}
+	}
+	if err := (&Options{}).LoadFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("Missing config file should fail to load.")
+	}
+}
+
+func TestOptionsLoadEnv(t *testing.T) {
+	t.Setenv("RINGOEXP_PORT", "7002")
+	t.Setenv("RINGOEXP_CONSUMER_HOSTNAME", "5.6.7.8")
+	t.Setenv("RINGOEXP_DEBUG_ENABLED", "true")
+	opts := &Options{Port: 6660, Name: "unchanged"}
+	if err := opts.LoadEnv(EnvPrefix); err != nil {
+		t.Fatalf("Environment not loaded: %s", err)
+	}
+	if opts.Port != 7002 || opts.ConsumerHostname != "5.6.7.8" || !opts.Debug || opts.Name != "unchanged" {
+		t.Errorf("Environment not applied. Received %s.", opts)
+	}
+
+	t.Setenv("RINGOEXP_RING_SIZE", "big")
+	if err := opts.LoadEnv(EnvPrefix); err == nil {
+		t.Errorf("Bad integer in the environment should fail to load.")
+	}
+}
@@ -1,22 +1,53 @@
 package server
 
+import "time"
+
 const (
-	version                 = "0.1.0"     // Application and server version.
-	DefaultHostname         = "localhost" // The hostname of the server.
-	DefaultPort             = 6660        // Port to receive requests: see IANA Port Numbers.
-	DefaultProfPort         = 0           // Profiler port to receive requests. *
-	DefaultConsumerHostname = "localhost" // The hostname of the remote consumer server.
-	DefaultConsumerPort     = 6660        // The port of the remote consumer server.
-	DefaultIsPublisher      = true        // Is the server a publisher? true = pub; false = consumer.
-	DefaultMaxConns         = 0           // Maximum number of incoming connections allowed (ws and/or web). *
-	DefaultMaxWorkers       = 1024        // Maximum number of outgoing worker connections allowed ( to consumer).
-	DefaultRingSize         = 4096        // Ring buffer size. Note this should be a power of 2. Ignored if consumer.
-	DefaultMaxProcs         = 0           // Maximum number of computer processors to utilize. *
+	version                 = "0.1.0"        // Application and server version.
+	DefaultHostname         = "localhost"    // The hostname of the server.
+	DefaultPort             = 6660           // Port to receive requests: see IANA Port Numbers.
+	DefaultProfPort         = 0              // Profiler port to receive requests. *
+	DefaultConsumerHostname = "localhost"    // The hostname of the remote consumer server.
+	DefaultConsumerPort     = 6661           // The port of the remote consumer server.
+	DefaultIsPublisher      = true           // Is the server a publisher? true = pub; false = consumer.
+	DefaultMaxConns         = 0              // Maximum number of incoming connections allowed (ws and/or web). *
+	DefaultMaxWorkers       = 1024           // Maximum number of outgoing worker connections allowed ( to consumer).
+	DefaultRingSize         = 4096           // Ring buffer size. Note this should be a power of 2. Ignored if consumer.
+	DefaultWaitStrategy     = "blocking"     // How ring sequences wait on each other. Ignored if consumer.
+	DefaultBusyWait         = 100            // Milliseconds ingest waits on a full ring before replying busy. Ignored if consumer.
+	DefaultDrainTimeout     = 30             // Seconds to forward work left in the ring on shutdown. Ignored if consumer.
+	DefaultStore            = "memory"       // Where a consumer stores its work: memory or file. Ignored if publisher.
+	DefaultStoreFile        = "ringoexp.dat" // The file a consumer appends work to if store is file.
+	DefaultStoreBatch       = 1              // Values a consumer buffers per write to the store. Ignored if publisher.
+	DefaultMaxProcs         = 0              // Maximum number of computer processors to utilize. *
 
 	// * zeros = no change or no limitation or not enabled.
 
+	EnvPrefix = "RINGOEXP_" // Prefix of environment variables that override options.
+
+	// Store types for consumer servers.
+	StoreMemory = "memory" // Keep work in memory.
+	StoreFile   = "file"   // Append work to a file.
+
 	// http and ws routes for servers.
 	wsRouteV1Ingest  = "/v1.0/ingest" // For the publisher or subscriber, this is the external endpoint.
 	httpRouteV1Alive = "/v1.0/alive"
 	httpRouteV1Stats = "/v1.0/stats"
+	httpRouteMetrics = "/metrics"      // Prometheus scrape endpoint.
+	httpRoutePprof   = "/debug/pprof/" // Profiler endpoints, on the profiler port only.
+)
+
+const (
+	workerRedialDelay      = 1 * time.Second        // Pause between attempts by a worker to reconnect to the consumer.
+	workerAckTimeout       = 5 * time.Second        // How long a worker waits on the consumer to ack.
+	ingestReserveTimeout   = 5 * time.Second        // How long an ingest connection waits on a full ring.
+	storeFlushInterval     = 1 * time.Second        // How often a part filled batch is written to the store.
+	drainPollInterval      = 100 * time.Millisecond // How often shutdown checks whether the ring has drained.
+	journalReleaseInterval = 1 * time.Second        // How often the journal checkpoints what the consumer has acked.
+	journalRetryDelay      = 1 * time.Second        // Pause before the journal retries a failed append.
+)
+
+const (
+	journalSegmentSize = 16 << 20 // Bytes written to a journal segment before starting another.
+	journalBatchMax    = 256      // Most values the journal stage appends in one record.
 )
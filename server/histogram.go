@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// histogramBuckets are the default upper bounds of a Histogram.
+var histogramBuckets = []time.Duration{
+	50 * time.Microsecond, 100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+// Histogram is a lock-free latency histogram with fixed buckets.
+type Histogram struct {
+	bounds []time.Duration // Upper bound of each bucket.
+	counts []int64         // Observations per bucket, plus one for those above every bound.
+	sum    int64           // Total nanoseconds observed.
+	count  int64           // Number of observations.
+}
+
+// HistogramNew is a factory function that returns a new Histogram. If no bounds are given the
+// default buckets from 50us to 5s are used.
+func HistogramNew(bounds ...time.Duration) *Histogram {
+	if len(bounds) == 0 {
+		bounds = histogramBuckets
+	}
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// Observe records one latency.
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+	atomic.AddInt64(&h.count, 1)
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// writeMetrics writes the histogram series in the Prometheus text format. labels, if not empty,
+// is a label list such as `stage="worker"` added to every series.
+func (h *Histogram) writeMetrics(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cum int64
+	for i, b := range h.bounds {
+		cum += atomic.LoadInt64(&h.counts[i])
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep,
+			strconv.FormatFloat(b.Seconds(), 'g', -1, 64), cum)
+	}
+	cum += atomic.LoadInt64(&h.counts[len(h.bounds)])
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, cum)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels,
+		strconv.FormatFloat(time.Duration(atomic.LoadInt64(&h.sum)).Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, cum)
+}
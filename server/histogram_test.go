@@ -0,0 +1,33 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	t.Parallel()
+	h := HistogramNew(time.Millisecond, 10*time.Millisecond)
+	h.Observe(500 * time.Microsecond)
+	h.Observe(time.Millisecond) // Bounds are inclusive.
+	h.Observe(5 * time.Millisecond)
+	h.Observe(time.Second)
+	if h.Count() != 4 {
+		t.Fatalf("Histogram count incorrect.\n\nExpected: 4\n\nActual: %d\n", h.Count())
+	}
+
+	var b bytes.Buffer
+	h.writeMetrics(&b, "test_seconds", `stage="x"`)
+	expected := strings.Join([]string{
+		`test_seconds_bucket{stage="x",le="0.001"} 2`,
+		`test_seconds_bucket{stage="x",le="0.01"} 3`,
+		`test_seconds_bucket{stage="x",le="+Inf"} 4`,
+		`test_seconds_sum{stage="x"} 1.0065`,
+		`test_seconds_count{stage="x"} 4`,
+	}, "\n") + "\n"
+	if b.String() != expected {
+		t.Errorf("Histogram metrics incorrect.\n\nExpected: %s\n\nActual: %s\n", expected, b.String())
+	}
+}
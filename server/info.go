@@ -15,6 +15,13 @@ type Info struct {
 	ConsumerHostname string `json:"consumerHostname"` // The hostname of the consumer server if this is a publisher.
 	ConsumerPort     int    `json:"consumerPort"`     // The port of the consumer server if this is a publisher.
 	MaxWorkers       int    `json:"maxWorkers"`       // The maximum outgoing workers allowed if publisher.
+	WaitStrategy     string `json:"waitStrategy"`     // How ring sequences wait on each other if publisher.
+	BusyWait         int    `json:"busyWait"`         // Milliseconds ingest waits on a full ring before replying busy if publisher, 0 = never.
+	DrainTimeout     int    `json:"drainTimeout"`     // Seconds to forward work left in the ring on shutdown if publisher.
+	JournalDir       string `json:"journalDir"`       // Directory journaling the ring if publisher, "" = no journal.
+	Store            string `json:"store"`            // Where work is stored (memory or file) if consumer.
+	StoreFile        string `json:"storeFile"`        // The file work is appended to if consumer and store is file.
+	StoreBatch       int    `json:"storeBatch"`       // The values buffered per write to the store if consumer.
 	ProfPort         int    `json:"profPort"`         // Profiler port the server is listening on.
 	Debug            bool   `json:"debugEnabled"`     // Is debugging enabled on the server.
 }
@@ -9,8 +9,9 @@ import (
 const (
 	testInfoExpectedJSONResult = `{"version":"9.8.7","UUID":"ABCDEFGHIJKLMNOPQRSTUVWXYZ",` +
 		`"name":"Test Server","hostname":"1.2.3.4","port":9999,"maxConns":9998,"isPublisher":` +
-		`true,"ringBuffSize":9997,"consumerHostname":"4.5.6.7","consumerPort":9996,` +
-		`"maxWorkers":9995,"profPort":9994,"debugEnabled":true}`
+		`true,"ringSize":9997,"consumerHostname":"4.5.6.7","consumerPort":9996,` +
+		`"maxWorkers":9995,"waitStrategy":"blocking","busyWait":9991,"drainTimeout":9992,"journalDir":"/tmp/journal",` +
+		`"store":"file","storeFile":"/tmp/test.dat","storeBatch":9993,"profPort":9994,"debugEnabled":true}`
 )
 
 func TestInfoNew(t *testing.T) {
@@ -22,10 +23,17 @@ func TestInfoNew(t *testing.T) {
 		i.Port = 9999
 		i.MaxConns = 9998
 		i.IsPublisher = true
-		i.RingBuffSize = 9997
+		i.RingSize = 9997
 		i.ConsumerHostname = "4.5.6.7"
 		i.ConsumerPort = 9996
 		i.MaxWorkers = 9995
+		i.WaitStrategy = "blocking"
+		i.BusyWait = 9991
+		i.DrainTimeout = 9992
+		i.JournalDir = "/tmp/journal"
+		i.Store = "file"
+		i.StoreFile = "/tmp/test.dat"
+		i.StoreBatch = 9993
 		i.ProfPort = 9994
 		i.Debug = true
 	})
@@ -57,10 +65,17 @@ func TestInfoString(t *testing.T) {
 		i.Port = 9999
 		i.MaxConns = 9998
 		i.IsPublisher = true
-		i.RingBuffSize = 9997
+		i.RingSize = 9997
 		i.ConsumerHostname = "4.5.6.7"
 		i.ConsumerPort = 9996
 		i.MaxWorkers = 9995
+		i.WaitStrategy = "blocking"
+		i.BusyWait = 9991
+		i.DrainTimeout = 9992
+		i.JournalDir = "/tmp/journal"
+		i.Store = "file"
+		i.StoreFile = "/tmp/test.dat"
+		i.StoreBatch = 9993
 		i.ProfPort = 9994
 		i.Debug = true
 	})
@@ -1,110 +1,139 @@
 package server
 
 import (
+	"context"
 	"fmt"
-	"runtime"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/composer22/ringoexp/protocol"
 	"golang.org/x/net/websocket"
 )
 
-type Ingester interface {
-	Run()
-	receive()
-	signalTrap()
-	shutDown()
+// MessageHandler does the work of the server mode on the values received over an ingest
+// connection: a publisher puts them in the ring, a consumer writes them to its store.
+type MessageHandler interface {
+	// Handle does the work on the values of frame f. It returns nil if the values are accepted
+	// and should be acked, or else the frame to reply with. An error closes the connection once
+	// any reply is sent. ctx is cancelled when the connection closes.
+	Handle(ctx context.Context, f *protocol.Frame, values []int32) (*protocol.Frame, error)
 }
 
 // Ingest is a wrapper around an incoming connection to a publishing/consuming server.
 type Ingest struct {
-	start time.Time       // The start time of the connection.
-	ws    *websocket.Conn // The socket to the remote client.
-	quit  chan bool       // Channel to signal service should disconnect and close down from server.
-	done  chan bool       // Channel to tell signalTrap() should close go routine.
-	log   *RingoExpLogger // Log file out.
-	swg   *sync.WaitGroup // Server synchronization of server close.
-	wg    sync.WaitGroup  // Synchronization of channel close.
+	start   time.Time          // The start time of the connection.
+	ws      *websocket.Conn    // The socket to the remote client.
+	quit    chan bool          // Channel to signal service should disconnect and close down from server.
+	done    chan bool          // Channel to tell signalTrap() should close go routine.
+	ctx     context.Context    // Cancelled with the connection to unblock any waits on the ring.
+	cancel  context.CancelFunc // Cancels ctx.
+	handler MessageHandler     // Does the work of the server mode on the values received.
+	acked   uint64             // Highest sequence accepted on the connection, for cumulative acks.
+	busy    *protocol.Frame    // The last Busy reply, until the frame it refused is sent again.
+	recvd   time.Time          // When the request being handled arrived, for ack latency.
+	stats   *Stats             // Server statistics to update.
+	log     *RingoExpLogger    // Log file out.
+	swg     *sync.WaitGroup    // Server synchronization of server close.
+	wg      sync.WaitGroup     // Synchronization of channel close.
 }
 
-// IngestNew is a factory function that returns a new Ingest instance
-func IngestNew(w *websocket.Conn, q chan bool, l *RingoExpLogger, swg *sync.WaitGroup) *Ingest {
+// IngestNew is a factory function that returns a new Ingest instance handling the values
+// received with h.
+func IngestNew(w *websocket.Conn, q chan bool, h MessageHandler, st *Stats, l *RingoExpLogger,
+	swg *sync.WaitGroup) *Ingest {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Ingest{
-		ws:   w,
-		quit: q,
-		done: make(chan bool),
-		log:  l,
-		swg:  swg,
+		ws:      w,
+		quit:    q,
+		done:    make(chan bool),
+		ctx:     ctx,
+		cancel:  cancel,
+		handler: h,
+		stats:   st,
+		log:     l,
+		swg:     swg,
 	}
 }
 
-// Run starts the event loop that manages the receiving of information from the remote client.
+// Run starts signalTrap() then handles requests from the remote client until it closes.
 func (i *Ingest) Run() {
 	i.start = time.Now()
 	i.swg.Add(1)      // We let the big boss know so it can micromanage us on server close.
 	i.wg.Add(1)       //   but we also have our own signal to signalTrap().
 	go i.signalTrap() // Spawn a background task to check for close requests.
-	i.receive()       // Then wait on incoming requests.
+	defer i.swg.Done()
+	i.stats.Add(&i.stats.IngestConns, 1)
+	defer i.stats.Add(&i.stats.IngestConns, -1)
+	i.receive() // Then wait on incoming requests.
 }
 
 // receive polls and handles any commands or information sent from the remote client.
 func (i *Ingest) receive() {
-	defer i.swg.Done()
+	defer i.shutDown()
 	remoteAddr := i.ws.Request().RemoteAddr
 	var req []byte
-	var err error
 	for {
 		// Receive data.
+		if err := websocket.Message.Receive(i.ws, &req); err != nil {
+			i.disconnected(remoteAddr, "receive", err)
+			return
+		}
 
-		if err = websocket.Message.Receive(i.ws, &req); err != nil {
-			switch {
-			case err.Error() == "EOF":
-				i.log.LogSession("disconnected", remoteAddr, "Client disconnected.")
-				i.shutDown()
-			case strings.Contains(err.Error(), "use of closed network connection"): // cntl-c safety.
-				i.shutDown()
-			default:
-				i.log.LogError(remoteAddr, fmt.Sprintf("Couldn't receive. Error: %s", err.Error()))
-				i.shutDown()
+		// Let the handler do the work, and ack the values if it accepts them.
+		f, values, reply := i.decode(req)
+		var err error
+		if reply == nil && i.busy != nil && f.Seq > i.busy.Seq {
+			reply = i.refuse(f, values)
+		}
+		if reply == nil {
+			reply, err = i.handler.Handle(i.ctx, f, values)
+			if err == nil && reply == nil {
+				reply = i.ack(f)
+			}
+			if reply != nil && reply.Type == protocol.TypeBusy {
+				i.busy = reply
+			}
+		}
+		if err != nil {
+			if i.ctx.Err() == nil { // Not closed by the server.
+				i.log.LogError(remoteAddr, fmt.Sprintf("Dropping client. Error: %s", err.Error()))
+			}
+			if reply != nil {
+				i.send(reply)
 			}
 			return
 		}
 
-		// Implement your own version of this and perform work
-
-		// ACK back we received.
-		if err = websocket.Message.Send(i.ws, 'a'); err != nil {
-			switch {
-			case err.Error() == "EOF":
-				i.log.LogSession("disconnected", remoteAddr, "Client disconnected.")
-				i.shutDown()
-			case strings.Contains(err.Error(), "use of closed network connection"): // cntl-c safety.
-				i.shutDown()
-			default:
-				i.log.LogError(remoteAddr, fmt.Sprintf("Couldn't receive. Error: %s", err.Error()))
-				i.shutDown()
-			}
+		// Reply to the client.
+		if err := i.send(reply); err != nil {
+			i.disconnected(remoteAddr, "send", err)
 			return
 		}
 	}
 }
 
-// signalTrap is a go routine used to poll quit requests from the server.
+// disconnected logs why the socket to the client failed on op.
+func (i *Ingest) disconnected(remoteAddr string, op string, err error) {
+	switch {
+	case err.Error() == "EOF":
+		i.log.LogSession("disconnected", remoteAddr, "Client disconnected.")
+	case strings.Contains(err.Error(), "use of closed network connection"): // cntl-c safety.
+	default:
+		i.log.LogError(remoteAddr, fmt.Sprintf("Couldn't %s. Error: %s", op, err.Error()))
+	}
+}
+
+// signalTrap is a go routine that waits on a quit request from the server or the end of the
+// connection, then closes the socket.
 func (i *Ingest) signalTrap() {
 	defer i.wg.Done()
-	for {
-		select {
-		case <-i.quit: // Server or receiver shutdown signal.
-			i.ws.Close() // Close the socket sends signal to receive()
-			return
-		case <-i.done:
-			i.ws.Close() // Close the socket sends signal to receive()
-			return
-		default:
-			runtime.Gosched()
-		}
+	defer i.cancel() // Release anything waiting on the ring for this connection.
+	select {
+	case <-i.quit: // Server or receiver shutdown signal.
+	case <-i.done:
 	}
+	i.ws.Close() // Close the socket sends signal to receive()
 }
 
 // shutDown shuts down sending/receiving.
@@ -112,3 +141,64 @@ func (i *Ingest) shutDown() {
 	close(i.done) // Signal to signalTrap()
 	i.wg.Wait()   // Wait for signalTrap()
 }
+
+// ack records the values of frame f as accepted and returns the cumulative Ack to reply with.
+func (i *Ingest) ack(f *protocol.Frame) *protocol.Frame {
+	last := f.LastSeq()
+	if last > i.acked {
+		i.acked = last
+	}
+	if i.busy != nil && last >= i.busy.Seq { // The busy frame was sent again.
+		i.busy = nil
+	}
+	i.stats.Add(&i.stats.Acked, int64(last-f.Seq+1))
+	return protocol.AckFrameNew(i.acked)
+}
+
+// refuse returns the Busy reply to frame f, sent after a frame answered busy. Taking it would
+// let the cumulative ack cover the busy frame, so the client must go back and send both again.
+func (i *Ingest) refuse(f *protocol.Frame, values []int32) *protocol.Frame {
+	retry, _ := i.busy.RetryAfter()
+	i.stats.Add(&i.stats.Busy, int64(len(values)))
+	return protocol.BusyFrameNew(f.Seq, retry)
+}
+
+// decode decodes the values of a request from the client, counting them in the stats.
+func (i *Ingest) decode(req []byte) (*protocol.Frame, []int32, *protocol.Frame) {
+	i.recvd = time.Now()
+	i.stats.Add(&i.stats.BytesIn, int64(len(req)))
+	f, values, reply := decodeValues(req)
+	i.stats.Add(&i.stats.Received, int64(len(values)))
+	return f, values, reply
+}
+
+// send encodes and sends a reply frame to the client.
+func (i *Ingest) send(reply *protocol.Frame) error {
+	b := protocol.Encode(reply)
+	if err := websocket.Message.Send(i.ws, b); err != nil {
+		return err
+	}
+	i.stats.Add(&i.stats.BytesOut, int64(len(b)))
+	if reply.Type == protocol.TypeAck {
+		i.stats.IngestAckLatency.Observe(time.Since(i.recvd))
+	}
+	return nil
+}
+
+// decodeValues decodes a Data or Batch frame received from the client. If the frame is bad, the
+// Error frame to reply with is returned instead.
+func decodeValues(req []byte) (*protocol.Frame, []int32, *protocol.Frame) {
+	f, err := protocol.Decode(req)
+	if err == nil && f.Type != protocol.TypeData && f.Type != protocol.TypeBatch {
+		err = protocol.ErrType
+	}
+	if err != nil {
+		var seq uint64
+		if f != nil {
+			seq = f.Seq
+		}
+		return f, nil, protocol.ErrorFrameNew(seq, protocol.ErrorCode(err), err.Error())
+	}
+	values, _ := f.Values()
+	return f, values, nil
+}
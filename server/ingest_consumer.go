@@ -1,64 +1,43 @@
 package server
 
 import (
-	"fmt"
-	"strings"
-	"sync"
+	"context"
+	"time"
 
-	"golang.org/x/net/websocket"
+	"github.com/composer22/ringoexp/protocol"
 )
 
-// IngestConsumer is a wrapper around an incoming connection to a publishing server.
-type IngestConsumer struct {
-	*Ingest
-	// add database pointer here for receive()
+// ConsumerHandler is the MessageHandler of a consuming server. It writes the values received to
+// the store.
+type ConsumerHandler struct {
+	store Store  // Where the work received is kept.
+	stats *Stats // Server statistics to update.
 }
 
-// IngestConsumerNew is a factory function that returns a new IngestConsumer instance
-func IngestConsumerNew(w *websocket.Conn, q chan bool, l *RingoExpLogger, swg *sync.WaitGroup) *IngestConsumer {
-	return &IngestConsumer{
-		IngestNew(w, q, l, swg),
+// ConsumerHandlerNew is a factory function that returns a new ConsumerHandler instance.
+func ConsumerHandlerNew(st Store, sts *Stats) *ConsumerHandler {
+	return &ConsumerHandler{
+		store: st,
+		stats: sts,
 	}
 }
 
-// receive polls and handles any commands or information sent from the remote client.
-func (i *IngestConsumer) receive() {
-	defer i.swg.Done()
-	remoteAddr := i.ws.Request().RemoteAddr
-	var req []byte
-	var err error
-	for {
-		// Receive data.
-
-		if err = websocket.Message.Receive(i.ws, &req); err != nil {
-			switch {
-			case err.Error() == "EOF":
-				i.log.LogSession("disconnected", remoteAddr, "Client disconnected.")
-				i.shutDown()
-			case strings.Contains(err.Error(), "use of closed network connection"): // cntl-c safety.
-				i.shutDown()
-			default:
-				i.log.LogError(remoteAddr, fmt.Sprintf("Couldn't receive. Error: %s", err.Error()))
-				i.shutDown()
-			}
-			return
-		}
-
-		// Store value to Database
-
-		// ACK back we received.
-		if err = websocket.Message.Send(i.ws, 'a'); err != nil {
-			switch {
-			case err.Error() == "EOF":
-				i.log.LogSession("disconnected", remoteAddr, "Client disconnected.")
-				i.shutDown()
-			case strings.Contains(err.Error(), "use of closed network connection"): // cntl-c safety.
-				i.shutDown()
-			default:
-				i.log.LogError(remoteAddr, fmt.Sprintf("Couldn't receive. Error: %s", err.Error()))
-				i.shutDown()
-			}
-			return
-		}
+// Handle writes the values to the store, and they are acked if the write succeeds. A batched
+// store may still be holding them in its buffer then, so batching is at most once: a consumer
+// crash loses values it has acked. A batched store that fails to write keeps what it holds and
+// fails the next write.
+func (h *ConsumerHandler) Handle(ctx context.Context, f *protocol.Frame,
+	values []int32) (*protocol.Frame, error) {
+	vals := make([]int, len(values))
+	for j, v := range values {
+		vals[j] = int(v)
+	}
+	start := time.Now()
+	err := h.store.Write(vals...)
+	h.stats.StoreLatency.Observe(time.Since(start))
+	if err != nil {
+		return protocol.ErrorFrameNew(f.Seq, protocol.CodeInternal, "Couldn't store."), err
 	}
+	h.stats.Add(&h.stats.Stored, int64(len(vals)))
+	return nil, nil
 }
@@ -1,75 +1,74 @@
 package server
 
 import (
-	"bytes"
-	"encoding/binary"
-	"strings"
-	"sync"
+	"context"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/composer22/ringoexp/protocol"
 	"github.com/composer22/ringoexp/ringbuffer"
-	"golang.org/x/net/websocket"
 )
 
-// IngestPublisher is a wrapper around an incoming connection to a publishing server.
-type IngestPublisher struct {
-	*Ingest
-	rb []int               // Ringbuffer for the data.
-	rm *ringbuffer.Manager // Synchronizer for work.
+// ErrRingFull is returned when the ring stays full for longer than an ingest connection waits.
+var ErrRingFull = errors.New("Ring full.")
+
+// PublisherHandler is the MessageHandler of a publishing server. It stores the values received
+// in the ring for the workers to forward.
+type PublisherHandler struct {
+	rb       []int               // Ringbuffer for the data.
+	rm       *ringbuffer.Manager // Synchronizer for work.
+	busyWait time.Duration       // How long to wait on a full ring before replying busy, 0 = never.
+	stats    *Stats              // Server statistics to update.
 }
 
-// IngestPublisherrNew is a factory function that returns a new IngestPublisher instance
-func IngestPublisherNew(w *websocket.Conn, q chan bool, r []int, m *ringbuffer.Manager,
-	l *RingoExpLogger, swg *sync.WaitGroup) *IngestPublisher {
-	return &IngestPublisher{
-		Ingest: IngestNew(w, q, l, swg),
-		rb:     r,
-		rm:     m,
+// PublisherHandlerNew is a factory function that returns a new PublisherHandler instance.
+func PublisherHandlerNew(r []int, m *ringbuffer.Manager, busyWait time.Duration,
+	st *Stats) *PublisherHandler {
+	return &PublisherHandler{
+		rb:       r,
+		rm:       m,
+		busyWait: busyWait,
+		stats:    st,
 	}
 }
 
-// receive polls and handles any commands or information sent from the remote client.
-func (i *IngestPublisher) receive() {
-	defer i.swg.Done()
-	remoteAddr := i.ws.Request().RemoteAddr
-	var req []byte
-	var err error
-	var mask = i.rm.Leader.Mask()
-	for {
-		// Receive data.
-
-		if err = websocket.Message.Receive(i.ws, &req); err != nil {
-			switch {
-			case err.Error() == "EOF":
-				i.log.Infof("Client %s disconnected.", remoteAddr)
-				i.shutDown()
-			case strings.Contains(err.Error(), "use of closed network connection"): // cntl-c safety.
-				i.shutDown()
-			default:
-				i.log.Infof("Couldn't receive from %s. Error: %s", remoteAddr, err.Error())
-				i.shutDown()
-			}
-			return
-		}
-
-		// Store into ring.
-		indx := i.rm.Leader.Reserve(1)
-		j, _ := binary.ReadVarint(bytes.NewBuffer(req))
-		i.rb[indx&mask] = int(j)
-		i.rm.Leader.Commit(indx, indx)
-
-		// ACK back we received.
-		if err = websocket.Message.Send(i.ws, 'a'); err != nil {
-			switch {
-			case err.Error() == "EOF":
-				i.log.Infof("Client %s disconnected.", remoteAddr)
-				i.shutDown()
-			case strings.Contains(err.Error(), "use of closed network connection"): // cntl-c safety.
-				i.shutDown()
-			default:
-				i.log.Infof("Couldn't receive from %s. Error: %s", remoteAddr, err.Error())
-				i.shutDown()
-			}
-			return
-		}
+// Handle stores the values in the ring. If the ring stays full for the busy wait, the client is
+// told to retry after as long again, so it slows down rather than piling up on the ring. With
+// no busy wait the client is dropped once the ring has been full for ingestReserveTimeout, so
+// it isn't left hanging on an ack. Either way it gives up if the connection closes.
+func (h *PublisherHandler) Handle(ctx context.Context, f *protocol.Frame,
+	values []int32) (*protocol.Frame, error) {
+	if len(values) > len(h.rb) {
+		return protocol.ErrorFrameNew(f.Seq, protocol.CodeTooLarge,
+			fmt.Sprintf("Batch exceeds ring size %d.", len(h.rb))), nil
+	}
+	count := int64(len(values))
+	timeout := ingestReserveTimeout
+	if h.busyWait > 0 {
+		timeout = h.busyWait
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	start := time.Now()
+	upper, err := h.rm.Leader.ReserveContext(ctx, count)
+	h.stats.ReserveWait(time.Since(start))
+	cancel()
+	if err == context.DeadlineExceeded && h.busyWait > 0 {
+		h.stats.Add(&h.stats.Busy, count)
+		return protocol.BusyFrameNew(f.Seq, h.busyWait), nil
+	}
+	if err == context.DeadlineExceeded {
+		return nil, ErrRingFull
+	}
+	if err != nil {
+		return nil, err
+	}
+	lower := upper - count + 1
+	mask := h.rm.Leader.Mask()
+	for j, v := range values {
+		h.rb[(lower+int64(j))&mask] = int(v)
 	}
+	h.rm.Leader.Commit(lower, upper)
+	h.stats.Occupancy() // Track the peak.
+	return nil, nil
 }
@@ -0,0 +1,420 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/composer22/ringoexp/protocol"
+	"github.com/composer22/ringoexp/ringbuffer"
+	"golang.org/x/net/websocket"
+)
+
+func TestIngestDecodeValues(t *testing.T) {
+	t.Parallel()
+	f, vals, reply := decodeValues(protocol.Encode(protocol.DataFrameNew(12, 34)))
+	if reply != nil || f.Seq != 12 || len(vals) != 1 || vals[0] != 34 {
+		t.Errorf("Data frame not decoded. Received %s %v %s.", f, vals, reply)
+	}
+	f, vals, reply = decodeValues(protocol.Encode(protocol.BatchFrameNew(20, []int32{1, 2, 3})))
+	if reply != nil || f.Seq != 20 || len(vals) != 3 || vals[2] != 3 {
+		t.Errorf("Batch frame not decoded. Received %s %v %s.", f, vals, reply)
+	}
+
+	tests := []struct {
+		name string
+		req  []byte
+		seq  uint64
+		code uint16
+	}{
+		{"short", []byte{1, 1}, 0, protocol.CodeMalformed},
+		{"ack", protocol.Encode(protocol.AckFrameNew(5)), 5, protocol.CodeType},
+		{"version", append([]byte{9}, protocol.Encode(protocol.DataFrameNew(6, 1))[1:]...), 6, protocol.CodeVersion},
+	}
+	for _, tc := range tests {
+		_, _, reply := decodeValues(tc.req)
+		if reply == nil || reply.Type != protocol.TypeError {
+			t.Errorf("Frame %s should be answered with an error.", tc.name)
+			continue
+		}
+		code, _, _ := reply.Error()
+		if reply.Seq != tc.seq || code != tc.code {
+			t.Errorf("Frame %s error reply incorrect. Received seq %d code %d.", tc.name, reply.Seq, code)
+		}
+	}
+}
+
+func TestIngestAckCumulative(t *testing.T) {
+	t.Parallel()
+	i := IngestNew(nil, nil, nil, StatsNew(), nil, nil)
+	tests := []struct {
+		frame *protocol.Frame
+		acked uint64
+	}{
+		{protocol.DataFrameNew(1, 0), 1},
+		{protocol.BatchFrameNew(2, []int32{0, 0, 0}), 4},
+		{protocol.DataFrameNew(3, 0), 4}, // A resent frame does not move the ack back.
+		{protocol.BatchFrameNew(10, []int32{0, 0}), 11},
+	}
+	for _, tc := range tests {
+		if reply := i.ack(tc.frame); reply.Type != protocol.TypeAck || reply.Seq != tc.acked {
+			t.Errorf("Ack of %s incorrect.\n\nExpected: %d\n\nActual: %s\n", tc.frame, tc.acked, reply)
+		}
+	}
+	if i.stats.Acked != 7 {
+		t.Errorf("Acked values should be counted.\n\nExpected: 7\n\nActual: %d\n", i.stats.Acked)
+	}
+}
+
+func TestPublisherHandler(t *testing.T) {
+	t.Parallel()
+	rm := ringbuffer.ManagerNew(4)
+	rb := make([]int, 4)
+	h := PublisherHandlerNew(rb, rm, 0, StatsNew())
+	ctx := context.Background()
+
+	for _, f := range []*protocol.Frame{protocol.DataFrameNew(1, 7), protocol.BatchFrameNew(2, []int32{8, 9})} {
+		values, _ := f.Values()
+		if reply, err := h.Handle(ctx, f, values); reply != nil || err != nil {
+			t.Fatalf("Frame %s should be accepted, received %s %v.", f, reply, err)
+		}
+	}
+	if expected := []int{7, 8, 9, 0}; !reflect.DeepEqual(rb, expected) {
+		t.Errorf("Values should be written to the ring.\n\nExpected: %v\n\nActual: %v\n", expected, rb)
+	}
+	if upper, err := rm.Follower.TryReserve(3); err != nil || upper != 2 {
+		t.Errorf("Values should be committed for the workers, reserved %d %v.", upper, err)
+	}
+
+	// A batch larger than the ring is refused, a cancelled wait on the ring closes the connection.
+	f := protocol.BatchFrameNew(4, []int32{1, 2, 3, 4, 5})
+	values, _ := f.Values()
+	if reply, err := h.Handle(ctx, f, values); err != nil || reply == nil || reply.Type != protocol.TypeError {
+		t.Errorf("Batch larger than the ring should be refused, received %s %v.", reply, err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	f = protocol.BatchFrameNew(4, []int32{1, 2})
+	values, _ = f.Values()
+	if _, err := h.Handle(cancelled, f, values); err != context.Canceled {
+		t.Errorf("Cancelled wait on a full ring should fail, received %v.", err)
+	}
+
+	// With a busy wait, the client is told to retry rather than waiting on the full ring.
+	st := StatsNew()
+	h = PublisherHandlerNew(rb, rm, 20*time.Millisecond, st)
+	reply, err := h.Handle(ctx, f, values)
+	if err != nil || reply == nil || reply.Type != protocol.TypeBusy || reply.Seq != 4 {
+		t.Fatalf("Full ring should reply busy, received %s %v.", reply, err)
+	}
+	if d, _ := reply.RetryAfter(); d != 20*time.Millisecond || st.Busy != 2 {
+		t.Errorf("Busy reply should retry after the busy wait and be counted, received %s %d.", d, st.Busy)
+	}
+}
+
+// testFailStore is a Store whose writes fail.
+type testFailStore struct{ MemoryStore }
+
+func (s *testFailStore) Write(values ...int) error { return errors.New("disk full") }
+
+func TestConsumerHandler(t *testing.T) {
+	t.Parallel()
+	ms := MemoryStoreNew()
+	st := StatsNew()
+	h := ConsumerHandlerNew(ms, st)
+	f := protocol.BatchFrameNew(1, []int32{4, 5, 6})
+	values, _ := f.Values()
+	if reply, err := h.Handle(context.Background(), f, values); reply != nil || err != nil {
+		t.Fatalf("Frame %s should be accepted, received %s %v.", f, reply, err)
+	}
+	if expected := []int{4, 5, 6}; !reflect.DeepEqual(ms.Values(), expected) || st.Stored != 3 {
+		t.Errorf("Values should be stored.\n\nExpected: %v\n\nActual: %v %d\n", expected, ms.Values(), st.Stored)
+	}
+
+	h = ConsumerHandlerNew(&testFailStore{}, st)
+	reply, err := h.Handle(context.Background(), f, values)
+	if err == nil || reply == nil || reply.Type != protocol.TypeError {
+		t.Errorf("Failed store should reply with an error and close, received %s %v.", reply, err)
+	}
+}
+
+// testIngestServer serves ingest connections over a real websocket, as the server's ingest
+// route does.
+type testIngestServer struct {
+	*httptest.Server
+	quit chan bool      // Closed to take the connections down, as on shutdown.
+	swg  sync.WaitGroup // Waits on the connections.
+}
+
+// testIngestServerNew starts a testIngestServer handing received values to h.
+func testIngestServerNew(h MessageHandler, st *Stats) *testIngestServer {
+	s := &testIngestServer{quit: make(chan bool)}
+	s.Server = httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		IngestNew(ws, s.quit, h, st, RingoExpLoggerNew(), &s.swg).Run()
+	}))
+	return s
+}
+
+// dial opens an ingest connection to the server.
+func (s *testIngestServer) dial(t *testing.T) *websocket.Conn {
+	ws, err := websocket.Dial(strings.Replace(s.URL, "http://", "ws://", 1), "", s.URL)
+	if err != nil {
+		t.Fatalf("Cannot dial ingest: %s", err.Error())
+	}
+	return ws
+}
+
+// shutdown takes the connections down and stops the server.
+func (s *testIngestServer) shutdown() {
+	close(s.quit)
+	s.swg.Wait()
+	s.Close()
+}
+
+// testIngestSend sends a frame on an ingest connection.
+func testIngestSend(t *testing.T, ws *websocket.Conn, f *protocol.Frame) {
+	if err := websocket.Message.Send(ws, protocol.Encode(f)); err != nil {
+		t.Fatalf("Cannot send frame %s: %s", f, err.Error())
+	}
+}
+
+// testIngestReply reads the next reply from an ingest connection.
+func testIngestReply(t *testing.T, ws *websocket.Conn) *protocol.Frame {
+	var resp []byte
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.Message.Receive(ws, &resp); err != nil {
+		t.Fatalf("Cannot receive reply: %s", err.Error())
+	}
+	reply, err := protocol.Decode(resp)
+	if err != nil {
+		t.Fatalf("Cannot decode reply: %s", err.Error())
+	}
+	return reply
+}
+
+func TestIngestRun(t *testing.T) {
+	t.Parallel()
+	rm := ringbuffer.ManagerNew(8)
+	rb := make([]int, 8)
+	ms := MemoryStoreNew()
+	tests := []struct {
+		name    string
+		handler MessageHandler
+		written func() []int
+	}{
+		{"publisher", PublisherHandlerNew(rb, rm, 0, StatsNew()), func() []int { return rb[:3] }},
+		{"consumer", ConsumerHandlerNew(ms, StatsNew()), ms.Values},
+	}
+	for _, tc := range tests {
+		srvr := testIngestServerNew(tc.handler, StatsNew())
+		ws := srvr.dial(t)
+		for _, f := range []*protocol.Frame{protocol.DataFrameNew(1, 1), protocol.BatchFrameNew(2, []int32{2, 3})} {
+			testIngestSend(t, ws, f)
+			if reply := testIngestReply(t, ws); reply.Type != protocol.TypeAck {
+				t.Errorf("Frame %s to %s should be acked, received %s.", f, tc.name, reply)
+			}
+		}
+		if expected := []int{1, 2, 3}; !reflect.DeepEqual(tc.written(), expected) {
+			t.Errorf("The %s handler should receive the values.\n\nExpected: %v\n\nActual: %v\n",
+				tc.name, expected, tc.written())
+		}
+		srvr.shutdown()
+		ws.Close()
+	}
+}
+
+func TestIngestRunRingFull(t *testing.T) {
+	t.Parallel()
+	rm := ringbuffer.ManagerNew(2) // Nothing follows, so two values fill the ring.
+	st := StatsNew()
+	srvr := testIngestServerNew(PublisherHandlerNew(make([]int, 2), rm, 0, StatsNew()), st)
+	ws := srvr.dial(t)
+	defer ws.Close()
+	testIngestSend(t, ws, protocol.BatchFrameNew(1, []int32{1, 2}))
+	if reply := testIngestReply(t, ws); reply.Type != protocol.TypeAck || reply.Seq != 2 {
+		t.Fatalf("Batch filling the ring should be acked, received %s.", reply)
+	}
+	testIngestSend(t, ws, protocol.DataFrameNew(3, 3))
+	for atomic.LoadInt64(&st.Received) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The connection waiting on the full ring is released on shutdown, not after the reserve times out.
+	start := time.Now()
+	srvr.shutdown()
+	if d := time.Since(start); d >= ingestReserveTimeout/2 {
+		t.Errorf("Shutdown should release a connection waiting on a full ring, took %s.", d)
+	}
+	var resp []byte
+	if err := websocket.Message.Receive(ws, &resp); err == nil {
+		t.Errorf("Frame waiting on a full ring should not be acked, received %v.", resp)
+	}
+}
+
+func TestIngestRunStoreFail(t *testing.T) {
+	t.Parallel()
+	srvr := testIngestServerNew(ConsumerHandlerNew(&testFailStore{}, StatsNew()), StatsNew())
+	defer srvr.shutdown()
+	ws := srvr.dial(t)
+	defer ws.Close()
+	testIngestSend(t, ws, protocol.BatchFrameNew(1, []int32{1, 2}))
+	reply := testIngestReply(t, ws)
+	if code, _, _ := reply.Error(); reply.Type != protocol.TypeError || reply.Seq != 1 || code != protocol.CodeInternal {
+		t.Errorf("Values the store fails to write should not be acked, received %s.", reply)
+	}
+	var resp []byte
+	if err := websocket.Message.Receive(ws, &resp); err == nil {
+		t.Errorf("Connection should be closed after a failed store, received %v.", resp)
+	}
+}
+
+func TestIngestRunMalformed(t *testing.T) {
+	t.Parallel()
+	ms := MemoryStoreNew()
+	srvr := testIngestServerNew(ConsumerHandlerNew(ms, StatsNew()), StatsNew())
+	defer srvr.shutdown()
+	ws := srvr.dial(t)
+	defer ws.Close()
+
+	// Malformed frames are answered with an error, and the connection stays open for the next.
+	wraps := protocol.BatchFrameNew(math.MaxUint64, []int32{1, 2})
+	tests := []struct {
+		name string
+		req  []byte
+		seq  uint64
+		code uint16
+	}{
+		{"short", []byte{1, 1}, 0, protocol.CodeMalformed},
+		{"ack", protocol.Encode(protocol.AckFrameNew(5)), 5, protocol.CodeType},
+		{"version", append([]byte{9}, protocol.Encode(protocol.DataFrameNew(6, 1))[1:]...), 6, protocol.CodeVersion},
+		{"batch wraps", protocol.Encode(wraps), math.MaxUint64, protocol.CodeMalformed},
+	}
+	for _, tc := range tests {
+		if err := websocket.Message.Send(ws, tc.req); err != nil {
+			t.Fatalf("Cannot send frame %s: %s", tc.name, err.Error())
+		}
+		reply := testIngestReply(t, ws)
+		if code, _, _ := reply.Error(); reply.Type != protocol.TypeError || reply.Seq != tc.seq || code != tc.code {
+			t.Errorf("Frame %s should be answered with error code %d, received %s.", tc.name, tc.code, reply)
+		}
+	}
+	testIngestSend(t, ws, protocol.DataFrameNew(1, 42))
+	if reply := testIngestReply(t, ws); reply.Type != protocol.TypeAck || reply.Seq != 1 {
+		t.Errorf("Frame after malformed ones should be acked, received %s.", reply)
+	}
+	if expected := []int{42}; !reflect.DeepEqual(ms.Values(), expected) {
+		t.Errorf("Only the valid frame should be stored.\n\nExpected: %v\n\nActual: %v\n", expected, ms.Values())
+	}
+}
+
+func TestIngestRunPipelined(t *testing.T) {
+	t.Parallel()
+	ms := MemoryStoreNew()
+	srvr := testIngestServerNew(ConsumerHandlerNew(ms, StatsNew()), StatsNew())
+	defer srvr.shutdown()
+	ws := srvr.dial(t)
+	defer ws.Close()
+
+	// Frames are sent ahead of their acks, and each ack covers everything accepted so far.
+	frames := []*protocol.Frame{
+		protocol.BatchFrameNew(1, []int32{1, 2}),
+		protocol.BatchFrameNew(3, []int32{3, 4, 5}),
+		protocol.DataFrameNew(6, 6),
+		protocol.DataFrameNew(2, 2), // A resend of an acked frame doesn't move the ack back.
+	}
+	for _, f := range frames {
+		testIngestSend(t, ws, f)
+	}
+	for i, expected := range []uint64{2, 5, 6, 6} {
+		if reply := testIngestReply(t, ws); reply.Type != protocol.TypeAck || reply.Seq != expected {
+			t.Errorf("Frame %s should be acked through %d, received %s.", frames[i], expected, reply)
+		}
+	}
+	expected, actual := []int{1, 2, 3, 4, 5, 6}, ms.Values()
+	if len(actual) < 6 || !reflect.DeepEqual(actual[:6], expected) {
+		t.Errorf("Pipelined values should be stored in order.\n\nExpected: %v\n\nActual: %v\n", expected, actual)
+	}
+}
+
+func TestIngestRunStats(t *testing.T) {
+	t.Parallel()
+	st := StatsNew()
+	srvr := testIngestServerNew(ConsumerHandlerNew(MemoryStoreNew(), st), st)
+	ws := srvr.dial(t)
+	defer ws.Close()
+
+	// Bytes count whole frames both ways, and a refused frame is received but not acked.
+	var in, out int64
+	for _, f := range []*protocol.Frame{protocol.BatchFrameNew(1, []int32{1, 2, 3}), protocol.AckFrameNew(4)} {
+		testIngestSend(t, ws, f)
+		in += int64(len(protocol.Encode(f)))
+		out += int64(len(protocol.Encode(testIngestReply(t, ws))))
+	}
+	r, a, s := atomic.LoadInt64(&st.Received), atomic.LoadInt64(&st.Acked), atomic.LoadInt64(&st.Stored)
+	if r != 3 || a != 3 || s != 3 {
+		t.Errorf("Values not counted. Received %d acked %d stored %d.", r, a, s)
+	}
+	if bi, bo := atomic.LoadInt64(&st.BytesIn), atomic.LoadInt64(&st.BytesOut); bi != in || bo != out {
+		t.Errorf("Bytes not counted.\n\nExpected: %d in %d out\n\nActual: %d in %d out\n", in, out, bi, bo)
+	}
+	if n := atomic.LoadInt64(&st.IngestConns); n != 1 {
+		t.Errorf("Open connection should be counted, found %d.", n)
+	}
+	srvr.shutdown()
+	if n := atomic.LoadInt64(&st.IngestConns); n != 0 {
+		t.Errorf("Closed connection should not be counted, found %d.", n)
+	}
+}
+
+func TestIngestRunBusy(t *testing.T) {
+	t.Parallel()
+	rm := ringbuffer.ManagerNew(2) // Nothing follows, so two values fill the ring.
+	rb := make([]int, 2)
+	st := StatsNew()
+	srvr := testIngestServerNew(PublisherHandlerNew(rb, rm, 20*time.Millisecond, st), st)
+	defer srvr.shutdown()
+	ws := srvr.dial(t)
+	defer ws.Close()
+
+	// The frames pipelined after a busy one are refused too, so no ack covers the busy frame.
+	frames := []*protocol.Frame{
+		protocol.BatchFrameNew(1, []int32{1, 2}),
+		protocol.DataFrameNew(3, 3),
+		protocol.DataFrameNew(4, 4),
+	}
+	for _, f := range frames {
+		testIngestSend(t, ws, f)
+	}
+	for i, expected := range []protocol.Type{protocol.TypeAck, protocol.TypeBusy, protocol.TypeBusy} {
+		if reply := testIngestReply(t, ws); reply.Type != expected || reply.Seq != frames[i].LastSeq() {
+			t.Fatalf("Frame %s should be answered with type %d, received %s.", frames[i], expected, reply)
+		}
+	}
+	j := rm.Follower.Reserve(2)
+	rm.Follower.Commit(j-1, j)
+	testIngestSend(t, ws, frames[2])
+	if reply := testIngestReply(t, ws); reply.Type != protocol.TypeBusy || reply.Seq != 4 {
+		t.Errorf("Frame after a busy one should be refused until it is sent again, received %s.", reply)
+	}
+
+	// Going back to the busy frame takes it and those after it.
+	for _, f := range frames[1:] {
+		testIngestSend(t, ws, f)
+		if reply := testIngestReply(t, ws); reply.Type != protocol.TypeAck || reply.Seq != f.Seq {
+			t.Errorf("Frame %s sent again should be acked, received %s.", f, reply)
+		}
+	}
+	if expected := []int{3, 4}; !reflect.DeepEqual(rb, expected) {
+		t.Errorf("Values sent again should be written to the ring.\n\nExpected: %v\n\nActual: %v\n", expected, rb)
+	}
+	if b := atomic.LoadInt64(&st.Busy); b != 3 {
+		t.Errorf("Busy and refused values should be counted, found %d.", b)
+	}
+}
@@ -0,0 +1,75 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// testCPU returns the CPU time the process has used.
+func testCPU(t *testing.T) time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		t.Fatalf("Cannot get CPU usage: %s", err.Error())
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+func TestIngestIdle(t *testing.T) {
+	const conns = 300
+	s := testServerStart(t, testValidOptions()) // A publisher, so its workers wait on the idle ring too.
+
+	addr := s.Addr().String()
+	clients := make([]*websocket.Conn, conns)
+	for n := range clients {
+		ws, err := websocket.Dial(fmt.Sprintf("ws://%s%s", addr, wsRouteV1Ingest), "",
+			fmt.Sprintf("http://%s/", addr))
+		if err != nil {
+			t.Fatalf("Cannot dial connection %d: %s", n, err.Error())
+		}
+		defer ws.Close()
+		clients[n] = ws
+	}
+	for i := 0; atomic.LoadInt64(&s.stats.IngestConns) != conns; i++ {
+		if i == 500 {
+			t.Fatalf("Server should hold %d connections, holds %d.", conns, atomic.LoadInt64(&s.stats.IngestConns))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Idle connections and workers should leave the process idle, not spin a go routine each.
+	const idle = 500 * time.Millisecond
+	before := testCPU(t)
+	time.Sleep(idle)
+	if used := testCPU(t) - before; used > idle/5 {
+		t.Errorf("Idle connections used %s of CPU in %s.", used, idle)
+	}
+
+	// Shutdown closes every connection and waits on them.
+	shut := make(chan bool)
+	go func() {
+		s.Shutdown()
+		close(shut)
+	}()
+	select {
+	case <-shut:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Shutdown did not return with %d idle connections.", conns)
+	}
+	if n := atomic.LoadInt64(&s.stats.IngestConns); n != 0 {
+		t.Errorf("Connections should be closed on shutdown, %d remain.", n)
+	}
+	var resp []byte
+	for n, ws := range clients {
+		ws.SetDeadline(time.Now().Add(time.Second))
+		if err := websocket.Message.Receive(ws, &resp); err == nil {
+			t.Errorf("Connection %d should be closed by the server.", n)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/composer22/ringoexp/ringbuffer"
+)
+
+const (
+	journalExt        = ".wal"       // Extension of segment files, named by their first journal sequence.
+	journalCheckpoint = "checkpoint" // File holding the journal sequence the consumer has acked through.
+	journalHeaderSize = 16           // Bytes of sequence, count and checksum leading each record.
+)
+
+// ErrJournalRecord is returned when a journal record is torn or corrupt.
+var ErrJournalRecord = errors.New("Journal record is torn or corrupt.")
+
+// Journal is a segmented write-ahead log of the values published to the ring, so work the consumer
+// hasn't acked survives a publisher crash. Values are numbered by journal sequence. The values
+// replayed into the ring keep the journal sequences they were written with, and are not written
+// again. The values after them are numbered by ring sequence, offset from the base.
+type Journal struct {
+	mu          sync.Mutex        // For locking access to the segments.
+	dir         string            // The directory holding the segments and checkpoint.
+	segmentSize int64             // Bytes written to a segment before starting another.
+	segments    []*journalSegment // Segments on disk, oldest first. The last is being appended to.
+	file        *os.File          // The segment being appended to.
+	size        int64             // Bytes written to file.
+	base        int64             // Journal sequence of the first ring sequence after the replay.
+	acked       int64             // Journal sequence the consumer has acked through.
+	replay      []int             // Unacked values found when the journal was opened.
+	replaySeqs  []int64           // Journal sequence of each value replayed, by ring sequence.
+}
+
+// journalSegment is a segment file and the range of journal sequences it holds.
+type journalSegment struct {
+	path  string // The segment file.
+	first int64  // First journal sequence in the segment.
+	last  int64  // The segment may be removed once the consumer has acked through this sequence.
+}
+
+// JournalNew is a factory function that returns a Journal in dir, creating the directory if need
+// be. Values left unacked by the last run are read back for Replay(), and a new segment started.
+func JournalNew(dir string, segmentSize int64) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		dir:         dir,
+		segmentSize: segmentSize,
+		acked:       -1,
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, journalCheckpoint)); err == nil && len(b) == 8 {
+		j.acked = int64(binary.BigEndian.Uint64(b))
+	}
+
+	// Read back the segments, oldest first. A torn record ends its segment.
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+journalExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	next := j.acked + 1
+	for _, p := range paths {
+		first, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(p), journalExt), 10, 64)
+		if err != nil {
+			continue // Not a segment.
+		}
+		last, err := j.read(p)
+		if err != nil && err != ErrJournalRecord {
+			return nil, err
+		}
+		if last < 0 { // Nothing was written, so the name may be taken by the new segment.
+			if err := os.Remove(p); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		j.segments = append(j.segments, &journalSegment{path: p, first: first, last: last})
+		if last+1 > next {
+			next = last + 1
+		}
+	}
+
+	// Replayed values stay in the old segments, which are removed as the consumer acks them.
+	j.base = next
+	if err := j.rotate(j.base); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// read collects the unacked values of a segment for replay, returning the last journal sequence in
+// it.
+func (j *Journal) read(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	last := int64(-1)
+	for {
+		seq, values, err := journalDecode(r)
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return last, err
+		}
+		for i, v := range values {
+			if seq+int64(i) > j.acked {
+				j.replay = append(j.replay, v)
+				j.replaySeqs = append(j.replaySeqs, seq+int64(i))
+			}
+		}
+		last = seq + int64(len(values)) - 1
+	}
+}
+
+// Replay returns the values left unacked by the last run, once. They should be published to the
+// ring again before any new work, from ring sequence 0. They are already journaled, so Append()
+// skips them.
+func (j *Journal) Replay() []int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	r := j.replay
+	j.replay = nil
+	return r
+}
+
+// Append durably writes the values published to the ring from ring sequence seq. There may be
+// no more than journalBatchMax values. Replayed values are skipped.
+func (j *Journal) Append(seq int64, values []int) error {
+	if len(values) == 0 || len(values) > journalBatchMax {
+		return fmt.Errorf("Journal appends take 1 to %d values, not %d.", journalBatchMax, len(values))
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if skip := min(int64(len(j.replaySeqs))-seq, int64(len(values))); skip > 0 {
+		seq += skip
+		values = values[skip:]
+		if len(values) == 0 {
+			return nil
+		}
+	}
+	jseq := j.journalSeq(seq)
+	if j.size >= j.segmentSize {
+		if err := j.rotate(jseq); err != nil {
+			return err
+		}
+	}
+	b := journalEncode(jseq, values)
+	if _, err := j.file.Write(b); err != nil {
+		return j.rollback(err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return j.rollback(err)
+	}
+	j.size += int64(len(b))
+	j.segments[len(j.segments)-1].last = jseq + int64(len(values)) - 1
+	return nil
+}
+
+// rollback cuts any part of a failed append from the segment, so a retry doesn't follow a torn
+// record, and returns err. The caller must hold the lock.
+func (j *Journal) rollback(err error) error {
+	if terr := j.file.Truncate(j.size); terr != nil {
+		return err
+	}
+	j.file.Seek(j.size, io.SeekStart)
+	return err
+}
+
+// Release records that the consumer has acked through ring sequence seq, and removes the segments
+// it no longer needs.
+func (j *Journal) Release(seq int64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if seq < 0 {
+		return nil
+	}
+	acked := j.journalSeq(seq)
+	if acked <= j.acked {
+		return nil
+	}
+
+	// Write the checkpoint aside and rename it over the old, so a crash leaves one or the other.
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(acked))
+	tmp := filepath.Join(j.dir, journalCheckpoint+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(j.dir, journalCheckpoint)); err != nil {
+		return err
+	}
+	j.acked = acked
+
+	active := len(j.segments) - 1
+	keep := j.segments[:0]
+	for i, s := range j.segments {
+		if i < active && s.last <= acked {
+			if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		keep = append(keep, s)
+	}
+	j.segments = keep
+	return nil
+}
+
+// journalSeq returns the journal sequence of ring sequence seq. The caller must hold the lock.
+func (j *Journal) journalSeq(seq int64) int64 {
+	if n := int64(len(j.replaySeqs)); seq < n {
+		return j.replaySeqs[seq]
+	}
+	return j.base + seq - int64(len(j.replaySeqs))
+}
+
+// Segments returns the number of segment files on disk.
+func (j *Journal) Segments() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.segments)
+}
+
+// Close syncs and closes the segment being appended to.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
+
+// rotate closes the segment being appended to and starts a new one from journal sequence first.
+// The caller must hold the lock.
+func (j *Journal) rotate(first int64) error {
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(j.dir, fmt.Sprintf("%020d%s", first, journalExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.file = f
+	j.size = 0
+	j.segments = append(j.segments, &journalSegment{path: path, first: first, last: first - 1})
+	return nil
+}
+
+// Run is the journal stage of the ring. It appends each batch the Leader commits to the journal
+// before committing it on to the workers, and every journalReleaseInterval releases what the
+// consumer has acked, whether or not new work arrives.
+// A batch that fails to append is retried, holding up the ring, as the workers must never see
+// values that aren't on disk.
+// The caller should add the stage to the server wait group before spawning Run().
+func (j *Journal) Run(ctx context.Context, rb []int, stage *ringbuffer.SeqMulti, rm *ringbuffer.Manager,
+	l *RingoExpLogger, swg *sync.WaitGroup) {
+	defer swg.Done()
+	var rwg sync.WaitGroup
+	rwg.Add(1)
+	go j.releaser(ctx, rm, l, &rwg)
+	defer rwg.Wait()
+	mask := stage.Mask()
+	values := make([]int, 0, journalBatchMax)
+	for {
+		upper, err := stage.ReserveContext(ctx, 1)
+		if err != nil {
+			return
+		}
+
+		// Take whatever else is waiting, so one sync covers many values.
+		lower := upper
+		for upper-lower+1 < journalBatchMax {
+			next, err := stage.TryReserve(1)
+			if err != nil {
+				break
+			}
+			upper = next
+		}
+		values = values[:0]
+		for i := lower; i <= upper; i++ {
+			values = append(values, rb[i&mask])
+		}
+		for {
+			err := j.Append(lower, values)
+			if err == nil {
+				break
+			}
+			l.Errorf("Journal couldn't append sequences %d to %d, retrying. Error: %s", lower, upper,
+				err.Error())
+			select {
+			case <-ctx.Done():
+				return // Left uncommitted, so never forwarded.
+			case <-time.After(journalRetryDelay):
+			}
+		}
+		stage.Commit(lower, upper)
+	}
+}
+
+// releaser is a go routine that releases what the consumer has acked every
+// journalReleaseInterval, until ctx is done.
+func (j *Journal) releaser(ctx context.Context, rm *ringbuffer.Manager, l *RingoExpLogger,
+	wg *sync.WaitGroup) {
+	defer wg.Done()
+	t := time.NewTicker(journalReleaseInterval)
+	defer t.Stop()
+	acked := ringbuffer.SequenceDefault
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		acked = rm.Consumed(acked)
+		if err := j.Release(acked); err != nil {
+			l.Errorf("Journal couldn't release through sequence %d. Error: %s", acked, err.Error())
+		}
+	}
+}
+
+// journalEncode returns a record of values from journal sequence seq: an 8 byte sequence, 4 byte
+// count and 4 byte CRC-32 of the record, then each value as 4 bytes, all big endian.
+func journalEncode(seq int64, values []int) []byte {
+	b := make([]byte, journalHeaderSize+4*len(values))
+	binary.BigEndian.PutUint64(b, uint64(seq))
+	binary.BigEndian.PutUint32(b[8:], uint32(len(values)))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(b[journalHeaderSize+4*i:], uint32(int32(v)))
+	}
+	binary.BigEndian.PutUint32(b[12:], journalChecksum(b))
+	return b
+}
+
+// journalChecksum returns the CRC-32 of a record, skipping the checksum itself.
+func journalChecksum(b []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(b[:12]), crc32.IEEETable, b[journalHeaderSize:])
+}
+
+// journalDecode reads the next record, returning io.EOF at a clean end and ErrJournalRecord if
+// the record is torn or corrupt.
+func journalDecode(r io.Reader) (int64, []int, error) {
+	h := make([]byte, journalHeaderSize)
+	if _, err := io.ReadFull(r, h); err != nil {
+		if err == io.EOF {
+			return 0, nil, err
+		}
+		return 0, nil, ErrJournalRecord
+	}
+	count := binary.BigEndian.Uint32(h[8:])
+	if count == 0 || count > journalBatchMax {
+		return 0, nil, ErrJournalRecord
+	}
+	b := append(h, make([]byte, 4*count)...)
+	if _, err := io.ReadFull(r, b[journalHeaderSize:]); err != nil ||
+		journalChecksum(b) != binary.BigEndian.Uint32(b[12:]) {
+		return 0, nil, ErrJournalRecord
+	}
+	values := make([]int, count)
+	for i := range values {
+		values[i] = int(int32(binary.BigEndian.Uint32(b[journalHeaderSize+4*i:])))
+	}
+	return int64(binary.BigEndian.Uint64(b)), values, nil
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/composer22/ringoexp/ringbuffer"
+)
+
+// testJournalReopen closes the journal and opens it again, as a restart would.
+func testJournalReopen(t *testing.T, j *Journal, segmentSize int64) *Journal {
+	if err := j.Close(); err != nil {
+		t.Fatalf("Journal close failed: %s", err.Error())
+	}
+	j, err := JournalNew(j.dir, segmentSize)
+	if err != nil {
+		t.Fatalf("Journal reopen failed: %s", err.Error())
+	}
+	return j
+}
+
+func TestJournalReplay(t *testing.T) {
+	t.Parallel()
+	j, err := JournalNew(t.TempDir(), journalSegmentSize)
+	if err != nil {
+		t.Fatalf("Journal open failed: %s", err.Error())
+	}
+	j.Append(0, []int{1, 2, 3})
+	j.Append(3, []int{4, -5})
+	j.Release(2)
+
+	j = testJournalReopen(t, j, journalSegmentSize)
+	if r := j.Replay(); !reflect.DeepEqual(r, []int{4, -5}) {
+		t.Errorf("Journal should replay the unacked values, received %v.", r)
+	}
+	if r := j.Replay(); r != nil {
+		t.Errorf("Journal should replay once, received %v.", r)
+	}
+
+	// The replayed values keep their records and aren't appended again, so crashing before they
+	// are acked replays each of them once, however often it happens.
+	j.Append(0, []int{4, -5})
+	j.Append(2, []int{6})
+	j.Release(0)
+	for i := 0; i < 2; i++ {
+		j = testJournalReopen(t, j, journalSegmentSize)
+		if r := j.Replay(); !reflect.DeepEqual(r, []int{-5, 6}) {
+			t.Errorf("Journal should replay each unacked value once, received %v.", r)
+		}
+		j.Append(0, []int{-5, 6})
+	}
+	j.Append(2, []int{7})
+	j.Release(2)
+	j = testJournalReopen(t, j, journalSegmentSize)
+	if r := j.Replay(); r != nil || j.Segments() != 2 {
+		t.Errorf("Journal should drop the old segments once the replay is acked, received %v and %d segments.",
+			r, j.Segments())
+	}
+	j.Close()
+}
+
+func TestJournalRetention(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	j, err := JournalNew(dir, 1) // Every append after the first starts a segment.
+	if err != nil {
+		t.Fatalf("Journal open failed: %s", err.Error())
+	}
+	defer j.Close()
+	for seq := int64(0); seq < 8; seq += 2 {
+		if err := j.Append(seq, []int{int(seq), int(seq) + 1}); err != nil {
+			t.Fatalf("Journal append failed: %s", err.Error())
+		}
+	}
+	if n := j.Segments(); n != 4 {
+		t.Fatalf("Journal should have 4 segments, has %d.", n)
+	}
+
+	// The segment being appended to is kept even once it is acked.
+	for _, tc := range []struct {
+		acked int64
+		keep  int
+	}{{0, 4}, {1, 3}, {4, 2}, {7, 1}} {
+		if err := j.Release(tc.acked); err != nil {
+			t.Fatalf("Journal release failed: %s", err.Error())
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*"+journalExt))
+		if j.Segments() != tc.keep || len(files) != tc.keep {
+			t.Errorf("Journal acked through %d should keep %d segments, has %d and %d files.",
+				tc.acked, tc.keep, j.Segments(), len(files))
+		}
+	}
+}
+
+func TestJournalTorn(t *testing.T) {
+	t.Parallel()
+	j, err := JournalNew(t.TempDir(), journalSegmentSize)
+	if err != nil {
+		t.Fatalf("Journal open failed: %s", err.Error())
+	}
+	j.Append(0, []int{1, 2})
+	j.Append(2, []int{3, 4})
+	path := j.segments[0].path
+	j.Close()
+
+	// Cut the last record short, as a crash mid write would.
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-3)
+	j, err = JournalNew(j.dir, journalSegmentSize)
+	if err != nil {
+		t.Fatalf("Journal reopen failed: %s", err.Error())
+	}
+	defer j.Close()
+	if r := j.Replay(); !reflect.DeepEqual(r, []int{1, 2}) {
+		t.Errorf("Journal should replay up to the torn record, received %v.", r)
+	}
+	if err := j.Append(0, nil); err == nil {
+		t.Errorf("Journal should reject an empty append.")
+	}
+}
+
+func TestJournalRunAppendFails(t *testing.T) {
+	t.Parallel()
+	j, err := JournalNew(t.TempDir(), journalSegmentSize)
+	if err != nil {
+		t.Fatalf("Journal open failed: %s", err.Error())
+	}
+	defer j.Close()
+	p := ringbuffer.PipelineNew(8)
+	stage := p.AddStage()
+	rm := &ringbuffer.Manager{Leader: p.Leader, Follower: p.AddStage(stage)}
+	p.Build()
+	rb := make([]int, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go j.Run(ctx, rb, stage, rm, RingoExpLoggerNew(), &wg)
+
+	// Break the segment being appended to, so appends fail.
+	j.mu.Lock()
+	j.file.Close()
+	j.mu.Unlock()
+	upper := rm.Leader.Reserve(2)
+	rm.Leader.Commit(upper-1, upper)
+	if _, err := rm.Follower.ReserveTimeout(1, 200*time.Millisecond); err == nil {
+		t.Fatalf("Follower should not see values the journal failed to append.")
+	}
+
+	// Once the journal can append again, the retry lets the values through.
+	j.mu.Lock()
+	j.file = nil
+	err = j.rotate(100)
+	j.mu.Unlock()
+	if err != nil {
+		t.Fatalf("Journal rotate failed: %s", err.Error())
+	}
+	if _, err := rm.Follower.ReserveTimeout(2, 5*time.Second); err != nil {
+		t.Errorf("Follower should see values once the journal appends them: %s", err.Error())
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestJournalRun(t *testing.T) {
+	t.Parallel()
+	j, err := JournalNew(t.TempDir(), journalSegmentSize)
+	if err != nil {
+		t.Fatalf("Journal open failed: %s", err.Error())
+	}
+	p := ringbuffer.PipelineNew(8)
+	stage := p.AddStage()
+	rm := &ringbuffer.Manager{Leader: p.Leader, Follower: p.AddStage(stage)}
+	p.Build()
+	rb := make([]int, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go j.Run(ctx, rb, stage, rm, RingoExpLoggerNew(), &wg)
+
+	upper := rm.Leader.Reserve(5)
+	for i := upper - 4; i <= upper; i++ {
+		rb[i] = int(i) * 10
+	}
+	rm.Leader.Commit(upper-4, upper)
+
+	// The follower only sees the values once journaled. Consume three of them.
+	got, err := rm.Follower.ReserveTimeout(3, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Follower should see journaled values: %s", err.Error())
+	}
+	rm.Follower.Commit(got-2, got)
+
+	// With no more work arriving, the checkpoint still follows the consumer.
+	for i := 0; ; i++ {
+		j.mu.Lock()
+		acked := j.acked
+		j.mu.Unlock()
+		if acked == 2 {
+			break
+		}
+		if i == 300 {
+			t.Fatalf("Idle journal should checkpoint what was consumed, acked through %d.", acked)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	j.Release(rm.Consumed(ringbuffer.SequenceDefault))
+	j = testJournalReopen(t, j, journalSegmentSize)
+	defer j.Close()
+	if r := j.Replay(); !reflect.DeepEqual(r, []int{30, 40}) {
+		t.Errorf("Journal should replay the values not consumed, received %v.", r)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net"
+	"sync"
+)
+
+// limitListener is a net.Listener that accepts at most max connections at once, like
+// netutil.LimitListener, except that the limit may be changed while it runs.
+type limitListener struct {
+	net.Listener
+	mu     sync.Mutex // For locking access to the counts.
+	cond   *sync.Cond // Signalled as connections close or the limit changes.
+	max    int        // The most connections open at once, 0 = unlimited.
+	active int        // Connections open or being accepted.
+	closed bool       // Has the listener been closed?
+}
+
+// limitListenerNew is a factory function that returns a listener accepting at most max
+// connections at once from l.
+func limitListenerNew(l net.Listener, max int) *limitListener {
+	ll := &limitListener{Listener: l, max: max}
+	ll.cond = sync.NewCond(&ll.mu)
+	return ll
+}
+
+// Accept waits for room under the limit, then for the next connection.
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	for l.max > 0 && l.active >= l.max && !l.closed {
+		l.cond.Wait()
+	}
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	l.active++
+	l.mu.Unlock()
+
+	c, err := l.Listener.Accept()
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: l.release}, nil
+}
+
+// Close closes the listener, releasing any Accept waiting on the limit.
+func (l *limitListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.cond.Broadcast()
+	l.mu.Unlock()
+	return l.Listener.Close()
+}
+
+// SetMax changes the limit. Connections already open over a lower limit are left open.
+func (l *limitListener) SetMax(max int) {
+	l.mu.Lock()
+	l.max = max
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+// release frees a connection's place under the limit.
+func (l *limitListener) release() {
+	l.mu.Lock()
+	l.active--
+	l.cond.Signal()
+	l.mu.Unlock()
+}
+
+// limitConn is a connection that frees its place under the limit once closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once // Makes sure release is only called once.
+	release func()    // Frees the place under the limit.
+}
+
+// Close closes the connection and frees its place.
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
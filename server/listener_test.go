@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitListener(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %s", err.Error())
+	}
+	l := limitListenerNew(ln, 1)
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Cannot dial: %s", err.Error())
+		}
+		defer c.Close()
+	}
+
+	// One is accepted, the rest wait until it closes or the limit is raised.
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatalf("Listener accepted a connection over the limit.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Close()
+	first.Close() // A second close should not free another place.
+	<-accepted
+	select {
+	case <-accepted:
+		t.Fatalf("Listener accepted a connection over the limit after a close.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.SetMax(0)
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Listener should accept once the limit is lifted.")
+	}
+
+	l.SetMax(1)
+	l.Close()
+	if _, ok := <-accepted; ok {
+		t.Errorf("Listener should stop accepting once closed.")
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// metricsPrefix is prepended to the name of every exported metric.
+const metricsPrefix = "ringoexp_"
+
+// writeMetrics writes the server statistics in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, st *Stats, info *Info) {
+	role := "consumer"
+	if info.IsPublisher {
+		role = "publisher"
+	}
+	writeMetricHeader(w, "info", "gauge", "Server details, always 1.")
+	fmt.Fprintf(w, "%sinfo{name=\"%s\",role=\"%s\",version=\"%s\"} 1\n", metricsPrefix, metricLabel(info.Name),
+		metricLabel(role), metricLabel(info.Version))
+	writeMetricHeader(w, "start_time_seconds", "gauge", "Unix time the server started.")
+	fmt.Fprintf(w, "%sstart_time_seconds %d\n", metricsPrefix, st.Start.Unix())
+
+	counters := []struct {
+		name  string
+		help  string
+		value *int64
+	}{
+		{"received_total", "Values received from ingest clients.", &st.Received},
+		{"acked_total", "Values acknowledged to ingest clients.", &st.Acked},
+		{"busy_total", "Values answered busy to ingest clients as the ring was full.", &st.Busy},
+		{"forwarded_total", "Values forwarded by workers and acknowledged by the consumer.", &st.Forwarded},
+		{"stored_total", "Values written to the store.", &st.Stored},
+		{"bytes_in_total", "Bytes received on websockets.", &st.BytesIn},
+		{"bytes_out_total", "Bytes sent on websockets.", &st.BytesOut},
+		{"reserves_total", "Reserves made by ingest clients on the ring.", &st.Reserves},
+	}
+	for _, c := range counters {
+		writeMetricHeader(w, c.name, "counter", c.help)
+		fmt.Fprintf(w, "%s%s %d\n", metricsPrefix, c.name, atomic.LoadInt64(c.value))
+	}
+	writeMetricHeader(w, "reserve_wait_seconds_total", "counter", "Time spent by ingest clients reserving the ring.")
+	fmt.Fprintf(w, "%sreserve_wait_seconds_total %g\n", metricsPrefix,
+		float64(atomic.LoadInt64(&st.ReserveWaitNs))/1e9)
+
+	// Ring gauges are only meaningful on a publisher.
+	if st.ring != nil {
+		occ := st.Occupancy()
+		writeMetricHeader(w, "ring_size", "gauge", "Cells in the ring.")
+		fmt.Fprintf(w, "%sring_size %d\n", metricsPrefix, info.RingSize)
+		writeMetricHeader(w, "ring_cursor", "gauge", "Highest index reserved by each ring sequence.")
+		fmt.Fprintf(w, "%sring_cursor{sequence=\"leader\"} %d\n", metricsPrefix, st.ring.Leader.Cursor())
+		fmt.Fprintf(w, "%sring_cursor{sequence=\"follower\"} %d\n", metricsPrefix, st.ring.Follower.Cursor())
+		writeMetricHeader(w, "ring_occupancy", "gauge", "Cells published and not yet taken by a worker.")
+		fmt.Fprintf(w, "%sring_occupancy %d\n", metricsPrefix, occ)
+		writeMetricHeader(w, "ring_occupancy_peak", "gauge", "Highest ring occupancy seen.")
+		fmt.Fprintf(w, "%sring_occupancy_peak %d\n", metricsPrefix, atomic.LoadInt64(&st.RingPeak))
+	}
+
+	writeMetricHeader(w, "ingest_connections", "gauge", "Active ingest client connections.")
+	fmt.Fprintf(w, "%singest_connections %d\n", metricsPrefix, atomic.LoadInt64(&st.IngestConns))
+	if info.IsPublisher {
+		writeMetricHeader(w, "workers", "gauge", "Workers in the pool by state.")
+		conns := atomic.LoadInt64(&st.WorkerConns)
+		fmt.Fprintf(w, "%sworkers{state=\"connected\"} %d\n", metricsPrefix, conns)
+		fmt.Fprintf(w, "%sworkers{state=\"disconnected\"} %d\n", metricsPrefix, int64(info.MaxWorkers)-conns)
+	}
+
+	writeMetricHeader(w, "ack_latency_seconds", "histogram",
+		"Time from a frame being sent or received to its ack, by stage.")
+	st.IngestAckLatency.writeMetrics(w, metricsPrefix+"ack_latency_seconds", `stage="ingest"`)
+	st.WorkerAckLatency.writeMetrics(w, metricsPrefix+"ack_latency_seconds", `stage="worker"`)
+	writeMetricHeader(w, "store_latency_seconds", "histogram", "Time taken to write values to the store.")
+	st.StoreLatency.writeMetrics(w, metricsPrefix+"store_latency_seconds", "")
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a metric.
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+// metricLabelEscaper escapes a label value as the exposition format requires: only backslash,
+// double quote and line feed, unlike a Go quoted string.
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricLabel returns v escaped for use between the quotes of a label value.
+func metricLabel(v string) string {
+	return metricLabelEscaper.Replace(v)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/composer22/ringoexp/ringbuffer"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	t.Parallel()
+	rm := ringbuffer.ManagerNew(8)
+	st := StatsNew(func(sts *Stats) {
+		sts.ring = rm
+	})
+	info := InfoNew(func(i *Info) {
+		i.Name = "test"
+		i.IsPublisher = true
+		i.RingSize = 8
+		i.MaxWorkers = 4
+	})
+	j := rm.Leader.Reserve(3)
+	rm.Leader.Commit(j-2, j)
+	st.Add(&st.Received, 3)
+	st.Add(&st.WorkerConns, 1)
+	st.WorkerAckLatency.Observe(time.Millisecond)
+
+	var b bytes.Buffer
+	writeMetrics(&b, st, info)
+	actual := b.String()
+	for _, line := range []string{
+		`ringoexp_info{name="test",role="publisher",version="` + version + `"} 1`,
+		"# TYPE ringoexp_received_total counter",
+		"ringoexp_received_total 3",
+		`ringoexp_ring_cursor{sequence="leader"} 2`,
+		`ringoexp_ring_cursor{sequence="follower"} -1`,
+		"ringoexp_ring_occupancy 3",
+		"ringoexp_ring_occupancy_peak 3",
+		`ringoexp_workers{state="connected"} 1`,
+		`ringoexp_workers{state="disconnected"} 3`,
+		"# TYPE ringoexp_ack_latency_seconds histogram",
+		`ringoexp_ack_latency_seconds_count{stage="worker"} 1`,
+		`ringoexp_ack_latency_seconds_count{stage="ingest"} 0`,
+		"ringoexp_store_latency_seconds_count 0",
+	} {
+		if !strings.Contains(actual, line+"\n") {
+			t.Errorf("Metrics missing line %q.\n\nActual: %s\n", line, actual)
+		}
+	}
+
+	// Consumers have no ring or workers.
+	b.Reset()
+	writeMetrics(&b, StatsNew(), InfoNew())
+	if strings.Contains(b.String(), "ringoexp_ring_") || strings.Contains(b.String(), "ringoexp_workers") {
+		t.Errorf("Consumer metrics should not report the ring or workers.\n\nActual: %s\n", b.String())
+	}
+}
+
+func TestMetricLabel(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"ringoexp", "ringoexp"},
+		{`a\b`, `a\\b`},
+		{`say "hi"`, `say \"hi\"`},
+		{"two\nlines", `two\nlines`},
+		{"tab\tand é", "tab\tand é"}, // Left as is, where Go quoting would escape them.
+	}
+	for _, tc := range tests {
+		if actual := metricLabel(tc.value); actual != tc.expected {
+			t.Errorf("Label value not escaped.\n\nExpected: %s\n\nActual: %s\n", tc.expected, actual)
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package server
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/composer22/ringoexp/ringbuffer"
+)
 
 // Options represents parameters that are passed to the application to be used in constructing
 // the server.
@@ -14,6 +20,13 @@ type Options struct {
 	ConsumerHostname string `json:"consumerHostname"` // The hostname of the consumer server if this is a publisher.
 	ConsumerPort     int    `json:"consumerPort"`     // The port of the consumer server if this is a publisher.
 	MaxWorkers       int    `json:"maxWorkers"`       // The maximum outgoing workers allowed if publisher.
+	WaitStrategy     string `json:"waitStrategy"`     // How ring sequences wait on each other if publisher.
+	BusyWait         int    `json:"busyWait"`         // Milliseconds ingest waits on a full ring before replying busy if publisher, 0 = never.
+	DrainTimeout     int    `json:"drainTimeout"`     // Seconds to forward work left in the ring on shutdown if publisher.
+	JournalDir       string `json:"journalDir"`       // Directory journaling the ring if publisher, "" = no journal.
+	Store            string `json:"store"`            // Where work is stored (memory or file) if consumer.
+	StoreFile        string `json:"storeFile"`        // The file work is appended to if consumer and store is file.
+	StoreBatch       int    `json:"storeBatch"`       // The values buffered per write to the store if consumer.
 	MaxProcs         int    `json:"maxProcs"`         // The maximum number of processor cores available.
 	ProfPort         int    `json:"profPort"`         // The profiler port of the server.
 	Debug            bool   `json:"debugEnabled"`     // Is debugging enabled in the application or server.
@@ -25,3 +38,100 @@ func (o *Options) String() string {
 	b, _ := json.Marshal(o)
 	return string(b)
 }
+
+// ValidationError lists every problem found in the options.
+type ValidationError []string
+
+// Error is an implementation of the error interface, returning one problem per line.
+func (v ValidationError) Error() string {
+	return "Invalid options:\n    " + strings.Join(v, "\n    ")
+}
+
+// Validate checks the options can run a server, returning a ValidationError of every problem.
+func (o *Options) Validate() error {
+	var v ValidationError
+	if o.Port < 1 || o.Port > 65535 {
+		v = append(v, fmt.Sprintf("port %d must be between 1 and 65535.", o.Port))
+	}
+	if o.MaxConns < 0 {
+		v = append(v, fmt.Sprintf("connections %d must be 0 (unlimited) or more.", o.MaxConns))
+	}
+	if o.ProfPort < 0 || o.ProfPort > 65535 {
+		v = append(v, fmt.Sprintf("profiler_port %d must be 0 (off) or between 1 and 65535.", o.ProfPort))
+	} else if o.ProfPort > 0 && o.ProfPort == o.Port {
+		v = append(v, fmt.Sprintf("profiler_port %d must differ from port.", o.ProfPort))
+	}
+
+	if o.IsPublisher {
+		switch {
+		case o.RingSize < 2 || o.RingSize&(o.RingSize-1) != 0:
+			v = append(v, fmt.Sprintf("ring_size %d must be a power of two, 2 or more (e.g. %d).",
+				o.RingSize, nextPowerOfTwo(o.RingSize)))
+		case int64(o.RingSize) > ringbuffer.LayoutDefault.SizeMax():
+			v = append(v, fmt.Sprintf("ring_size %d must be no more than %d.", o.RingSize,
+				ringbuffer.LayoutDefault.SizeMax()))
+		}
+		if o.MaxWorkers < 1 {
+			v = append(v, fmt.Sprintf("workers %d must be 1 or more.", o.MaxWorkers))
+		}
+		if o.ConsumerHostname == "" {
+			v = append(v, "consumer_hostname must be set.")
+		}
+		if o.ConsumerPort < 1 || o.ConsumerPort > 65535 {
+			v = append(v, fmt.Sprintf("consumer_port %d must be between 1 and 65535.", o.ConsumerPort))
+		} else if o.ConsumerPort == o.Port && sameHost(o.ConsumerHostname, o.Hostname) {
+			v = append(v, fmt.Sprintf("consumer %s:%d is this publisher; set consumer_hostname or "+
+				"consumer_port to a consumer server.", o.ConsumerHostname, o.ConsumerPort))
+		}
+		if o.BusyWait < 0 {
+			v = append(v, fmt.Sprintf("busy_wait %d must be 0 (never busy) or more.", o.BusyWait))
+		}
+		if o.DrainTimeout < 0 {
+			v = append(v, fmt.Sprintf("drain_timeout %d must be 0 (abandon the ring) or more.", o.DrainTimeout))
+		}
+		if _, err := ringbuffer.WaitStrategyNew(o.WaitStrategy); err != nil {
+			v = append(v, fmt.Sprintf("wait_strategy %q must be one of %s, %s, %s or %s.", o.WaitStrategy,
+				ringbuffer.WaitBusySpin, ringbuffer.WaitYielding, ringbuffer.WaitSleeping, ringbuffer.WaitBlocking))
+		}
+	} else {
+		switch o.Store {
+		case StoreMemory:
+		case StoreFile:
+			if o.StoreFile == "" {
+				v = append(v, "store_file must be set when store is file.")
+			}
+		default:
+			v = append(v, fmt.Sprintf("store %q must be %s or %s.", o.Store, StoreMemory, StoreFile))
+		}
+		if o.StoreBatch < 1 {
+			v = append(v, fmt.Sprintf("store_batch %d must be 1 (unbatched) or more.", o.StoreBatch))
+		}
+	}
+
+	if len(v) > 0 {
+		return v
+	}
+	return nil
+}
+
+// nextPowerOfTwo returns the smallest power of two at least n.
+func nextPowerOfTwo(n int) int {
+	p := 2
+	for p < n && int64(p) < ringbuffer.LayoutDefault.SizeMax() {
+		p <<= 1
+	}
+	return p
+}
+
+// sameHost returns whether two hostnames name the same machine as far as can be told without a
+// lookup: they match, or both are loopback or unspecified addresses.
+func sameHost(a, b string) bool {
+	local := func(h string) bool {
+		switch strings.ToLower(h) {
+		case "", "localhost", "0.0.0.0", "127.0.0.1", "::", "::1":
+			return true
+		}
+		return false
+	}
+	return strings.EqualFold(a, b) || (local(a) && local(b))
+}
@@ -2,13 +2,16 @@ package server
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
 const (
 	testOptionsExpectedJSONResult = `{"name":"Test Server","hostname":"1.2.3.4",` +
-		`"port":9999,"maxConns":9998,"isPublisher":true,"ringBuffSize":9997,"consumerHostname":` +
-		`"5.6.7.8","consumerPort":9996,"maxWorkers":9995,"maxProcs":9994,"profPort":9993,` +
+		`"port":9999,"maxConns":9998,"isPublisher":true,"ringSize":9997,"consumerHostname":` +
+		`"5.6.7.8","consumerPort":9996,"maxWorkers":9995,"waitStrategy":"blocking","busyWait":9990,"drainTimeout":9991,` +
+		`"journalDir":"/tmp/journal","store":"file",` +
+		`"storeFile":"/tmp/test.dat","storeBatch":9992,"maxProcs":9994,"profPort":9993,` +
 		`"debugEnabled":true}`
 )
 
@@ -20,10 +23,17 @@ func TestOptionsString(t *testing.T) {
 		Port:             9999,
 		MaxConns:         9998,
 		IsPublisher:      true,
-		RingBuffSize:     9997,
+		RingSize:         9997,
 		ConsumerHostname: "5.6.7.8",
 		ConsumerPort:     9996,
 		MaxWorkers:       9995,
+		WaitStrategy:     "blocking",
+		BusyWait:         9990,
+		DrainTimeout:     9991,
+		JournalDir:       "/tmp/journal",
+		Store:            "file",
+		StoreFile:        "/tmp/test.dat",
+		StoreBatch:       9992,
 		MaxProcs:         9994,
 		ProfPort:         9993,
 		Debug:            true,
@@ -34,3 +44,70 @@ func TestOptionsString(t *testing.T) {
 			testOptionsExpectedJSONResult, actual)
 	}
 }
+
+// testValidOptions returns a valid set of publisher options to break in tests.
+func testValidOptions() *Options {
+	return &Options{
+		Hostname:         DefaultHostname,
+		Port:             DefaultPort,
+		IsPublisher:      true,
+		RingSize:         DefaultRingSize,
+		ConsumerHostname: DefaultConsumerHostname,
+		ConsumerPort:     DefaultConsumerPort,
+		MaxWorkers:       DefaultMaxWorkers,
+		WaitStrategy:     DefaultWaitStrategy,
+		BusyWait:         DefaultBusyWait,
+		DrainTimeout:     DefaultDrainTimeout,
+		Store:            DefaultStore,
+		StoreFile:        DefaultStoreFile,
+		StoreBatch:       DefaultStoreBatch,
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	t.Parallel()
+	if err := testValidOptions().Validate(); err != nil {
+		t.Fatalf("Default options should be valid: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(o *Options)
+		expect []string
+	}{
+		{"ring not power of two", func(o *Options) { o.RingSize = 500 }, []string{"ring_size 500 must be a power of two, 2 or more (e.g. 512)"}},
+		{"ring too large", func(o *Options) { o.RingSize = 1 << 31 }, []string{"no more than"}},
+		{"ports", func(o *Options) { o.Port = 0; o.ConsumerPort = 70000 },
+			[]string{"port 0", "consumer_port 70000"}},
+		{"self", func(o *Options) { o.Hostname = "0.0.0.0"; o.ConsumerPort = o.Port }, []string{"is this publisher"}},
+		{"workers", func(o *Options) { o.MaxWorkers = 0 }, []string{"workers 0"}},
+		{"wait", func(o *Options) { o.WaitStrategy = "nap" }, []string{`wait_strategy "nap"`}},
+		{"drain", func(o *Options) { o.DrainTimeout = -1 }, []string{"drain_timeout -1"}},
+		{"busy", func(o *Options) { o.BusyWait = -1 }, []string{"busy_wait -1"}},
+		{"profiler", func(o *Options) { o.ProfPort = o.Port }, []string{"profiler_port"}},
+		{"consumer store", func(o *Options) { o.IsPublisher = false; o.Store = "disk"; o.StoreBatch = 0 },
+			[]string{`store "disk"`, "store_batch 0"}},
+		{"consumer ignores ring", func(o *Options) { o.IsPublisher = false; o.RingSize = 500 }, nil},
+	}
+	for _, tc := range tests {
+		o := testValidOptions()
+		tc.change(o)
+		err := o.Validate()
+		if tc.expect == nil {
+			if err != nil {
+				t.Errorf("Options %s should be valid: %s", tc.name, err)
+			}
+			continue
+		}
+		v, ok := err.(ValidationError)
+		if !ok || len(v) != len(tc.expect) {
+			t.Errorf("Options %s should report %d problems, received: %v", tc.name, len(tc.expect), err)
+			continue
+		}
+		for i, e := range tc.expect {
+			if !strings.Contains(v[i], e) {
+				t.Errorf("Options %s problem %q should mention %q.", tc.name, v[i], e)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,8 +15,7 @@ import (
 )
 
 const (
-	testRingoExpLogExpCnt = `{"connected":{"method":"GET","url":{"Scheme":"http","Opaque":"","User":null,` +
-		`"Host":"www.ladeda.com","Path":"","RawQuery":"","Fragment":""},"proto":"HTTP/1.1",` +
+	testRingoExpLogExpCnt = `{"connected":{"method":"GET","url":%s,"proto":"HTTP/1.1",` +
 		`"header":{},"host":"ladeda.com","remoteAddr":"127.8.9.10","requestURI":` +
 		`"ws://www.ladeda.com/v1.0/chat"}}`
 	testRingoExpLogExpSess = `{"disconnected":{"remoteAddr":"127.8.9.10","message":"Client disconnected."}}`
@@ -35,10 +35,12 @@ func TestLogConnect(t *testing.T) {
 		RemoteAddr: "127.8.9.10",
 		RequestURI: "ws://www.ladeda.com/v1.0/chat",
 	}
+	// The url.URL json layout varies with the go release, so marshal it the same way.
+	ub, _ := json.Marshal(u)
 	expectOutput(t, func() {
 		l := RingoExpLoggerNew()
 		l.LogConnect(r)
-	}, fmt.Sprintf("%s%s\n", testLbl, testRingoExpLogExpCnt))
+	}, fmt.Sprintf("%s%s\n", testLbl, fmt.Sprintf(testRingoExpLogExpCnt, ub)))
 }
 
 func TestLogSession(t *testing.T) {
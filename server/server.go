@@ -2,39 +2,50 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
-	"os/signal"
+	"reflect"
 	"runtime"
 	"sync"
 	"time"
 
-	// Allow dynamic profiling.
-	_ "net/http/pprof"
-
 	"github.com/composer22/ringoexp/logger"
 	"github.com/composer22/ringoexp/ringbuffer"
-	"golang.org/x/net/netutil"
 	"golang.org/x/net/websocket"
 )
 
 // Server is the main structure that represents a server instance.
 type Server struct {
-	mu         sync.RWMutex        // For locking access to server attributes.
-	running    bool                // Is the server running?
-	info       *Info               // Basic server information used to run the server.
-	opts       *Options            // Original options used to create the server.
-	stats      *Stats              // Server statistics since it started.
-	srvr       *http.Server        // HTTP/Socket server.
-	ringbuffer []int               // A struct for the work
-	rm         *ringbuffer.Manager // Manager wraps trackers for the work added to the ringbuffer
-	quit       chan bool           // A channel to signal to web sockets and workers to close.
-	log        *RingoExpLogger     // Log instance for recording error and other messages.
-	wg         sync.WaitGroup      // Wait group to sync socket going down.
+	mu         sync.RWMutex             // For locking access to server attributes.
+	running    bool                     // Is the server running?
+	info       *Info                    // Basic server information used to run the server.
+	opts       *Options                 // Original options used to create the server.
+	stats      *Stats                   // Server statistics since it started.
+	srvr       *http.Server             // HTTP/Socket server.
+	prof       *http.Server             // Profiler server, if enabled.
+	ln         *limitListener           // Listener limiting the incoming connections.
+	reload     func() (*Options, error) // Loads fresh options for Reload().
+	ringbuffer []int                    // A struct for the work
+	rm         *ringbuffer.Manager      // Manager wraps trackers for the work added to the ringbuffer
+	jstage     *ringbuffer.SeqMulti     // The journal stage between the Leader and Follower, if journaling.
+	journal    *Journal                 // Write-ahead log of the ring, if journaling.
+	store      Store                    // Where a consumer keeps the work it receives.
+	pool       []*Worker                // Workers forwarding to the consumer, if publisher.
+	quit       chan bool                // A channel to signal to web sockets and workers to close.
+	ctx        context.Context          // Cancelled with quit to unblock any waits on the ring.
+	cancel     context.CancelFunc       // Cancels ctx.
+	log        *RingoExpLogger          // Log instance for recording error and other messages.
+	wg         sync.WaitGroup           // Wait group to sync socket going down.
+	workers    sync.WaitGroup           // Wait group to sync workers going down.
+	stopped    chan bool                // Closed once Shutdown has finished.
+	replayed   chan bool                // Closed once the journal is replayed, holding back new ingest.
+	stop       sync.Once                // So concurrent calls to Shutdown take the server down once.
 }
 
 // New is a factory function that returns a new server instance.
@@ -51,30 +62,68 @@ func New(ops *Options) *Server {
 			i.ConsumerHostname = ops.ConsumerHostname
 			i.ConsumerPort = ops.ConsumerPort
 			i.MaxWorkers = ops.MaxWorkers
+			i.WaitStrategy = ops.WaitStrategy
+			i.BusyWait = ops.BusyWait
+			i.DrainTimeout = ops.DrainTimeout
+			i.JournalDir = ops.JournalDir
+			i.Store = ops.Store
+			i.StoreFile = ops.StoreFile
+			i.StoreBatch = ops.StoreBatch
 			i.ProfPort = ops.ProfPort
 			i.Debug = ops.Debug
 		}),
 		opts:       ops,
-		stats:      StatsNew(),
 		ringbuffer: make([]int, ops.RingSize),
 		rm:         ringbuffer.ManagerNew(int64(ops.RingSize)),
 		quit:       make(chan bool),
+		stopped:    make(chan bool),
+		replayed:   make(chan bool),
 		log:        RingoExpLoggerNew(),
 	}
 
+	// Journaling adds a stage the workers wait on: Leader -> journal -> Follower -> Leader.
+	if ops.IsPublisher && ops.JournalDir != "" {
+		p := ringbuffer.PipelineNew(int64(ops.RingSize))
+		s.jstage = p.AddStage()
+		s.rm = &ringbuffer.Manager{Leader: p.Leader, Follower: p.AddStage(s.jstage)}
+		p.Build()
+	}
+	s.stats = StatsNew(func(st *Stats) {
+		if ops.IsPublisher {
+			st.ring = s.rm
+		}
+	})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
 	if s.info.Debug {
 		s.log.SetLogLevel(logger.Debug)
 	}
 
-	// Setup the routes.
-	http.Handle(wsRouteV1Ingest, websocket.Handler(s.ingestHandler))
-	http.HandleFunc(httpRouteV1Alive, s.aliveHandler)
-	http.HandleFunc(httpRouteV1Stats, s.statsHandler)
+	// Each sequence gets its own wait strategy since blocking strategies hold wait state.
+	seqs := []*ringbuffer.SeqMulti{s.rm.Leader, s.rm.Follower}
+	if s.jstage != nil {
+		seqs = append(seqs, s.jstage)
+	}
+	for _, seq := range seqs {
+		w, err := ringbuffer.WaitStrategyNew(s.info.WaitStrategy)
+		if err != nil {
+			s.log.Errorf("%s Using %s.", err.Error(), ringbuffer.WaitYielding)
+			w = ringbuffer.YieldingWaitNew()
+		}
+		seq.SetWaitStrategy(w)
+	}
+
+	// Setup the routes on a mux of our own, so servers may run side by side in one process.
+	mux := http.NewServeMux()
+	mux.Handle(wsRouteV1Ingest, websocket.Handler(s.ingestHandler))
+	mux.HandleFunc(httpRouteV1Alive, s.aliveHandler)
+	mux.HandleFunc(httpRouteV1Stats, s.statsHandler)
+	mux.HandleFunc(httpRouteMetrics, s.metricsHandler)
 	s.srvr = &http.Server{
-		Addr: fmt.Sprintf("%s:%d", s.info.Hostname, s.info.Port),
+		Addr:    fmt.Sprintf("%s:%d", s.info.Hostname, s.info.Port),
+		Handler: mux,
 	}
 
-	s.handleSignals()
 	return s
 }
 
@@ -98,13 +147,34 @@ func (s *Server) Start() error {
 		s.log.Errorf("Cannot create net.listener: %s", err.Error())
 		return err
 	}
-	// If we want to limit connections, created a special listener with a throttle.
-	if s.info.MaxConns > 0 {
-		ln = netutil.LimitListener(ln, s.info.MaxConns)
+	// Consumers need somewhere to put the work.
+	if !s.info.IsPublisher {
+		st, err := StoreNew(s.info.Store, s.info.StoreFile, s.info.StoreBatch)
+		if err != nil {
+			ln.Close()
+			s.log.Errorf("Cannot open store: %s", err.Error())
+			return err
+		}
+		s.mu.Lock()
+		s.store = st
+		s.mu.Unlock()
+	}
+	// Journaling publishers replay the work the consumer hadn't acked before they stopped.
+	if s.jstage != nil {
+		j, err := JournalNew(s.info.JournalDir, journalSegmentSize)
+		if err != nil {
+			ln.Close()
+			s.log.Errorf("Cannot open journal: %s", err.Error())
+			return err
+		}
+		s.journal = j
 	}
 
 	s.mu.Lock()
 
+	// Throttle connections with a listener whose limit may be changed by a reload.
+	s.ln = limitListenerNew(ln, s.info.MaxConns)
+
 	// Pprof http endpoint for the profiler.
 	if s.info.ProfPort > 0 {
 		s.StartProfiler()
@@ -113,7 +183,23 @@ func (s *Server) Start() error {
 	s.stats.Start = time.Now()
 	s.running = true
 	s.mu.Unlock()
-	err = s.srvr.Serve(ln)
+
+	// Publishers forward work from the ring to the consumer in the background.
+	if s.info.IsPublisher {
+		s.startWorkers()
+	}
+	if s.journal != nil {
+		s.workers.Add(2)
+		go s.journal.Run(s.ctx, s.ringbuffer, s.jstage, s.rm, s.log, &s.workers)
+		go s.replay(s.journal.Replay())
+	} else {
+		close(s.replayed)
+	}
+	err = s.srvr.Serve(s.ln)
+	if err == http.ErrServerClosed {
+		<-s.stopped // Shutdown closed the listener, so let it finish draining.
+		return nil
+	}
 
 	// Done.
 	s.mu.Lock()
@@ -125,57 +211,264 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// StartProfiler is called to enable dynamic profiling.
+// Addr returns the address the server is listening on, or nil if it hasn't started. Useful when
+// the port is 0, so chosen by the system.
+func (s *Server) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Store returns where a consumer keeps the work it receives, or nil if not a consumer or not
+// started.
+func (s *Server) Store() Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store
+}
+
+// StartProfiler is called to enable dynamic profiling. pprof is only served on the profiler port.
 func (s *Server) StartProfiler() {
 	s.log.Infof("Starting profiling on http port %d", s.opts.ProfPort)
-	hp := fmt.Sprintf("%s:%d", s.info.Hostname, s.info.ProfPort)
-	go func() {
-		err := http.ListenAndServe(hp, nil)
-		if err != nil {
+	s.prof = &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", s.info.Hostname, s.info.ProfPort),
+		Handler: profilerMux(),
+	}
+	go func(prof *http.Server) {
+		err := prof.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			s.log.Emergencyf("Error starting profile monitoring service: %s", err)
 		}
-	}()
+	}(s.prof)
+}
+
+// profilerMux returns the pprof routes. Importing net/http/pprof also registers them on
+// http.DefaultServeMux, which no server serves.
+func profilerMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpRoutePprof, pprof.Index)
+	mux.HandleFunc(httpRoutePprof+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(httpRoutePprof+"profile", pprof.Profile)
+	mux.HandleFunc(httpRoutePprof+"symbol", pprof.Symbol)
+	mux.HandleFunc(httpRoutePprof+"trace", pprof.Trace)
+	return mux
+}
+
+// startWorkers spins up the pool of workers that forward work from the ringbuffer to the consumer.
+func (s *Server) startWorkers() {
+	s.mu.Lock()
+	s.log.Infof("Starting %d workers to consumer %s:%d", s.info.MaxWorkers, s.info.ConsumerHostname,
+		s.info.ConsumerPort)
+	s.resizeWorkers(s.info.MaxWorkers)
+	s.mu.Unlock()
 }
 
-// Shutdown takes down the server gracefully back to an initialize state.
+// resizeWorkers starts or stops workers until max are running. The caller must hold the lock.
+func (s *Server) resizeWorkers(max int) {
+	if s.ctx.Err() != nil {
+		return // Shutting down.
+	}
+	for len(s.pool) < max {
+		w := WorkerNew(len(s.pool), s.info.ConsumerHostname, s.info.ConsumerPort, s.ringbuffer, s.rm,
+			s.ctx, s.stats, s.log, &s.workers)
+		s.workers.Add(1)
+		go w.Run()
+		s.pool = append(s.pool, w)
+	}
+	for len(s.pool) > max {
+		s.pool[len(s.pool)-1].Stop()
+		s.pool = s.pool[:len(s.pool)-1]
+	}
+}
+
+// Shutdown takes down the server gracefully back to an initialize state. New connections are
+// refused and ingest sockets closed, then a publisher's workers are given the drain timeout to
+// forward the work left in the ring before they stop, and a consumer's store is flushed. A
+// second call waits on the first to finish.
 func (s *Server) Shutdown() {
-	if !s.isRunning() {
-		return
+	if s.isRunning() {
+		s.stop.Do(s.shutdown)
 	}
+}
+
+// shutdown does the work of Shutdown.
+func (s *Server) shutdown() {
 	s.log.Infof("BEGIN server service stop.")
-	s.log.Infof("Shutting down sockets...")
+	s.log.Infof("Shutting down listener and sockets...")
+	s.srvr.Close()
+	if s.prof != nil {
+		s.prof.Close()
+	}
 	close(s.quit)
 	s.wg.Wait()
+
+	var pending int64
+	if s.info.IsPublisher {
+		pending = s.rm.Pending()
+		s.log.Infof("Draining %d values from the ring to the consumer...", pending)
+		s.drain(time.Duration(s.info.DrainTimeout) * time.Second)
+	}
+	s.log.Infof("Shutting down workers...")
+	s.mu.Lock() // So a reload can't start workers as they are waited on.
+	s.cancel()
+	s.mu.Unlock()
+	s.workers.Wait()
+	if s.info.IsPublisher {
+		abandoned := s.rm.Pending()
+		var unjournaled int64 // Committed to the ring but not yet appended to the journal.
+		if s.jstage != nil {
+			unjournaled = s.rm.PendingAt(s.jstage)
+		}
+		switch {
+		case abandoned == 0:
+			s.log.Infof("Drained %d values.", pending)
+		case s.journal != nil && unjournaled == 0:
+			s.log.Infof("Drained %d values, %d left in the journal to replay on restart.",
+				pending-abandoned, abandoned)
+		case s.journal != nil:
+			s.log.Errorf("Drained %d values, %d left in the journal to replay on restart, abandoned %d "+
+				"not yet journaled.", pending-abandoned, abandoned-unjournaled, unjournaled)
+		default:
+			s.log.Errorf("Drained %d values, abandoned %d left in the ring.", pending-abandoned, abandoned)
+		}
+	}
+	if s.journal != nil {
+		s.log.Infof("Closing journal...")
+		if err := s.journal.Release(s.rm.Consumed(ringbuffer.SequenceDefault)); err != nil {
+			s.log.Errorf("Error releasing journal: %s", err.Error())
+		}
+		if err := s.journal.Close(); err != nil {
+			s.log.Errorf("Error closing journal: %s", err.Error())
+		}
+	}
+
+	if s.store != nil {
+		s.log.Infof("Flushing and closing store...")
+		if err := s.store.Close(); err != nil {
+			s.log.Errorf("Error closing store: %s", err.Error())
+		}
+	}
 	s.mu.Lock()
 	s.running = false
 	s.mu.Unlock()
+	close(s.stopped)
 	s.log.Infof("END server service stop.")
 }
 
-// handleSignals responds to operating system interrupts such as application kills.
-func (s *Server) handleSignals() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		for sig := range c {
-			s.log.Infof("Server received signal: %v\n", sig)
-			s.Shutdown()
-			s.log.Infof("Server exiting.")
-			os.Exit(0)
+// replay is a go routine that publishes the values the journal held from the last run to the
+// ring. New ingest waits on it, so the values go ahead of any new work, while the server still
+// serves its other routes and may be shut down.
+func (s *Server) replay(values []int) {
+	defer s.workers.Done()
+	defer close(s.replayed)
+	if len(values) == 0 {
+		return
+	}
+	s.log.Infof("Replaying %d values from the journal...", len(values))
+	mask := s.rm.Leader.Mask()
+	for len(values) > 0 {
+		count := min(len(values), len(s.ringbuffer), journalBatchMax)
+		upper, err := s.rm.Leader.ReserveContext(s.ctx, int64(count))
+		if err != nil {
+			return
+		}
+		lower := upper - int64(count) + 1
+		for j, v := range values[:count] {
+			s.ringbuffer[(lower+int64(j))&mask] = v
+		}
+		s.rm.Leader.Commit(lower, upper)
+		values = values[count:]
+	}
+}
+
+// drain waits for the workers to forward the work left in the ring to the consumer, giving up
+// after timeout.
+func (s *Server) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for s.rm.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// SetReloader sets the function Reload() uses to load fresh options, such as from the command
+// line, environment and config file.
+func (s *Server) SetReloader(f func() (*Options, error)) {
+	s.mu.Lock()
+	s.reload = f
+	s.mu.Unlock()
+}
+
+// Reload loads fresh options and applies those safe to change while running: debug output, the
+// connection limit and the worker count. Other changes are logged as needing a restart.
+func (s *Server) Reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reload == nil {
+		s.log.Errorf("Reload requested, but the server has no options to reload.")
+		return
+	}
+	ops, err := s.reload()
+	if err != nil {
+		s.log.Errorf("Reload failed, keeping the running options. %s", err.Error())
+		return
+	}
+
+	was, now := reflect.ValueOf(s.opts).Elem(), reflect.ValueOf(ops).Elem()
+	changed := false
+	for i := 0; i < was.NumField(); i++ {
+		f := was.Type().Field(i)
+		if reflect.DeepEqual(was.Field(i).Interface(), now.Field(i).Interface()) {
+			continue
 		}
-	}()
+		changed = true
+		switch f.Name {
+		case "Debug", "MaxConns", "MaxWorkers":
+			s.log.Infof("Reload changed %s from %v to %v.", f.Tag.Get("json"), was.Field(i), now.Field(i))
+		default:
+			s.log.Errorf("Reload found %s changed from %v to %v, which needs a restart.", f.Tag.Get("json"),
+				was.Field(i), now.Field(i))
+		}
+	}
+	if !changed {
+		s.log.Infof("Reload found no changes.")
+	}
+
+	s.opts.Debug, s.info.Debug = ops.Debug, ops.Debug
+	if ops.Debug {
+		s.log.SetLogLevel(logger.Debug)
+	} else {
+		s.log.SetLogLevel(logger.Info)
+	}
+	s.opts.MaxConns, s.info.MaxConns = ops.MaxConns, ops.MaxConns
+	if s.ln != nil {
+		s.ln.SetMax(ops.MaxConns)
+	}
+	s.opts.MaxWorkers, s.info.MaxWorkers = ops.MaxWorkers, ops.MaxWorkers
+	if s.info.IsPublisher && s.running {
+		s.resizeWorkers(ops.MaxWorkers)
+	}
 }
 
 // ingestHandler is the main entry point to handle chat connections to the client.
 func (s *Server) ingestHandler(ws *websocket.Conn) {
-	var ingester Ingester
+	var h MessageHandler
 	s.log.LogConnect(ws.Request())
-	if s.opts.IsPublisher {
-		ingester = IngestPublisherNew(ws, s.quit, s.ringbuffer, s.rm, s.log, &s.wg)
+	s.mu.RLock()
+	if s.info.IsPublisher {
+		h = PublisherHandlerNew(s.ringbuffer, s.rm, time.Duration(s.info.BusyWait)*time.Millisecond, s.stats)
 	} else {
-		ingester = IngestConsumerNew(ws, s.quit, s.log, &s.wg)
+		h = ConsumerHandlerNew(s.store, s.stats)
+	}
+	s.mu.RUnlock()
+	select {
+	case <-s.replayed:
+	case <-s.quit:
+		return
 	}
-	ingester.Run()
+	IngestNew(ws, s.quit, h, s.stats, s.log, &s.wg).Run()
 }
 
 // aliveHandler handles a client http:// "is the server alive?" request.
@@ -207,6 +500,16 @@ func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
+// metricsHandler handles a Prometheus scrape of the server statistics. Scrapes are not logged as
+// they arrive every few seconds.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.mu.RLock()
+	info := *s.info // A reload may change the info while the scrape is written.
+	s.mu.RUnlock()
+	writeMetrics(w, s.stats, &info)
+}
+
 // initResponseHeader sets up the common http response headers for the return of all json calls.
 func (s *Server) initResponseHeader(w http.ResponseWriter) {
 	h := w.Header()
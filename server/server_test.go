@@ -1,3 +1,246 @@
 package server
 
-// TBD
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/composer22/ringoexp/protocol"
+	"github.com/composer22/ringoexp/ringbuffer"
+	"golang.org/x/net/websocket"
+)
+
+func TestServerDrain(t *testing.T) {
+	t.Parallel()
+	s := &Server{rm: ringbuffer.ManagerNew(8), log: RingoExpLoggerNew()}
+	s.rm.Leader.Commit(0, s.rm.Leader.Reserve(3))
+
+	// Nothing is consuming, so the drain should give up at the timeout.
+	start := time.Now()
+	s.drain(200 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || s.rm.Pending() != 3 {
+		t.Fatalf("Drain should time out with the ring intact, took %s with %d pending.", elapsed,
+			s.rm.Pending())
+	}
+
+	// A slow consumer should be waited on.
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			j := s.rm.Follower.Reserve(1)
+			s.rm.Follower.Commit(j, j)
+		}
+	}()
+	s.drain(5 * time.Second)
+	if p := s.rm.Pending(); p != 0 {
+		t.Errorf("Drain should wait for the ring to empty, %d pending.", p)
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	t.Parallel()
+	ops := testValidOptions()
+	ops.IsPublisher = false
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %s", err.Error())
+	}
+	defer ln.Close()
+	s := &Server{opts: ops, info: InfoNew(), ln: limitListenerNew(ln, 0), stats: StatsNew(),
+		log: RingoExpLoggerNew()}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.cancel()
+
+	// Scrapes and logging carry on while the reloads apply.
+	scraped := make(chan struct{})
+	go func() {
+		defer close(scraped)
+		for i := 0; i < 20; i++ {
+			s.metricsHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, httpRouteMetrics, nil))
+			s.log.Debugf("Scraped %d", i)
+		}
+	}()
+	defer func() { <-scraped }()
+
+	// Without a reloader, or if loading fails, nothing changes.
+	s.Reload()
+	s.SetReloader(func() (*Options, error) { return nil, errors.New("bad config") })
+	s.Reload()
+
+	fresh := *ops
+	fresh.Debug = true
+	fresh.MaxConns = 5
+	fresh.Port = 7000
+	s.SetReloader(func() (*Options, error) { f := fresh; return &f, nil })
+	s.Reload()
+	if !s.opts.Debug || !s.info.Debug || s.opts.MaxConns != 5 || s.ln.max != 5 {
+		t.Errorf("Reload should apply debug and connections, received %s.", s.opts)
+	}
+	if s.opts.Port != DefaultPort {
+		t.Errorf("Reload should not apply the port, received %d.", s.opts.Port)
+	}
+}
+
+func TestServerResizeWorkers(t *testing.T) {
+	t.Parallel()
+	s := &Server{
+		info:       InfoNew(func(i *Info) { i.ConsumerHostname = "127.0.0.1"; i.ConsumerPort = 1 }),
+		ringbuffer: make([]int, 8),
+		rm:         ringbuffer.ManagerNew(8),
+		stats:      StatsNew(),
+		log:        RingoExpLoggerNew(),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.resizeWorkers(3)
+	stopped := s.pool[1:]
+	s.resizeWorkers(1)
+	s.mu.Unlock()
+	if len(s.pool) != 1 {
+		t.Fatalf("Resize should leave 1 worker, has %d.", len(s.pool))
+	}
+	for _, w := range stopped {
+		if w.idle.Err() == nil {
+			t.Errorf("Worker %d should be stopped.", w.id)
+		}
+	}
+
+	// Once shutting down, no more workers are started.
+	s.cancel()
+	s.mu.Lock()
+	s.resizeWorkers(4)
+	s.mu.Unlock()
+	s.workers.Wait()
+	if len(s.pool) != 1 {
+		t.Errorf("Resize should not start workers once shutting down, has %d.", len(s.pool))
+	}
+}
+
+// testServerStart starts a server on a port chosen by the system, returning once it listens.
+func testServerStart(t *testing.T, ops *Options) *Server {
+	ops.Hostname = "127.0.0.1"
+	ops.Port = 0
+	s := New(ops)
+	go s.Start()
+	for i := 0; s.Addr() == nil; i++ {
+		if i == 500 {
+			t.Fatalf("Server did not start listening.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s
+}
+
+func TestServerShutdownTwice(t *testing.T) {
+	t.Parallel()
+	s := testServerStart(t, testValidOptions())
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Shutdown() // As from a signal and a deferred call at once.
+		}()
+	}
+	wg.Wait()
+	if s.isRunning() {
+		t.Errorf("Server should be stopped once both calls return.")
+	}
+	s.Shutdown()
+}
+
+func TestServerReplayBackground(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	j, err := JournalNew(dir, journalSegmentSize)
+	if err != nil {
+		t.Fatalf("Journal open failed: %s", err.Error())
+	}
+	j.Append(0, []int{1, 2, 3, 4})
+	j.Close()
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	ln.Close() // Nothing listens here, so the workers can't empty the ring.
+
+	// The replay overfills the ring, but the server still serves, holding new work behind it.
+	ops := testValidOptions()
+	ops.RingSize = 2
+	ops.MaxWorkers = 1
+	ops.DrainTimeout = 0
+	ops.ConsumerHostname = "127.0.0.1"
+	ops.ConsumerPort = ln.Addr().(*net.TCPAddr).Port
+	ops.JournalDir = dir
+	s := testServerStart(t, ops)
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s%s", s.Addr(), httpRouteV1Alive))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Server should serve while replaying, received %v %v.", resp, err)
+	}
+	resp.Body.Close()
+	url := fmt.Sprintf("ws://%s%s", s.Addr(), wsRouteV1Ingest)
+	ws, err := websocket.Dial(url, "", fmt.Sprintf("http://%s", s.Addr()))
+	if err != nil {
+		t.Fatalf("Cannot dial ingest: %s", err.Error())
+	}
+	defer ws.Close()
+	websocket.Message.Send(ws, protocol.Encode(protocol.DataFrameNew(1, 5)))
+	ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	var reply []byte
+	if err := websocket.Message.Receive(ws, &reply); err == nil {
+		t.Errorf("New work should wait on the replay, received %v.", reply)
+	}
+
+	// Shutdown stops the replay waiting on the full ring.
+	done := make(chan bool)
+	go func() {
+		s.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Shutdown should stop the replay.")
+	}
+}
+
+func TestServerSideBySide(t *testing.T) {
+	consumerOps := testValidOptions()
+	consumerOps.IsPublisher = false
+	consumer := testServerStart(t, consumerOps)
+	defer consumer.Shutdown()
+
+	publisherOps := testValidOptions()
+	publisherOps.ConsumerHostname = "127.0.0.1"
+	publisherOps.ConsumerPort = consumer.Addr().(*net.TCPAddr).Port
+	publisherOps.MaxWorkers = 2
+	publisher := testServerStart(t, publisherOps)
+	defer publisher.Shutdown()
+
+	// Each serves its own routes, and neither serves the profiler.
+	for _, s := range []*Server{consumer, publisher} {
+		for route, code := range map[string]int{httpRouteV1Alive: 200, httpRoutePprof: 404} {
+			resp, err := http.Get(fmt.Sprintf("http://%s%s", s.Addr(), route))
+			if err != nil {
+				t.Fatalf("Cannot get %s: %s", route, err.Error())
+			}
+			resp.Body.Close()
+			if resp.StatusCode != code {
+				t.Errorf("Server %s %s returned %d, expected %d.", s.Addr(), route, resp.StatusCode, code)
+			}
+		}
+	}
+}
+
+func TestServerProfilerMux(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	profilerMux().ServeHTTP(w, httptest.NewRequest("GET", httpRoutePprof, nil))
+	if w.Code != 200 {
+		t.Errorf("Profiler should serve %s, returned %d.", httpRoutePprof, w.Code)
+	}
+}
@@ -0,0 +1,185 @@
+// Package servertest runs a consumer and a publisher server side by side in one process, on
+// ports chosen by the system, for end to end tests of the ingest path.
+package servertest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/composer22/ringoexp/protocol"
+	"github.com/composer22/ringoexp/server"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	startTimeout = 5 * time.Second       // How long a server has to start listening.
+	pollInterval = 10 * time.Millisecond // How often waits check on the servers.
+)
+
+// Pair is a consumer server and a publisher server forwarding to it.
+type Pair struct {
+	Consumer  *server.Server // Stores what the publisher forwards, in memory.
+	Publisher *server.Server // Accepts the values sent by clients.
+}
+
+// PairNew is a factory function that starts a consumer, then a publisher forwarding to it.
+// configure, if not nil, may change the options of each before they start.
+func PairNew(configure func(publisher, consumer *server.Options)) (*Pair, error) {
+	consumer := &server.Options{
+		Hostname:   "127.0.0.1",
+		Store:      server.StoreMemory,
+		StoreBatch: 1,
+	}
+	publisher := &server.Options{
+		Hostname:         "127.0.0.1",
+		IsPublisher:      true,
+		RingSize:         64,
+		ConsumerHostname: "127.0.0.1",
+		MaxWorkers:       4,
+		WaitStrategy:     server.DefaultWaitStrategy,
+		BusyWait:         server.DefaultBusyWait,
+		DrainTimeout:     server.DefaultDrainTimeout,
+	}
+	if configure != nil {
+		configure(publisher, consumer)
+	}
+
+	p := &Pair{}
+	var err error
+	if p.Consumer, err = start(consumer); err != nil {
+		return nil, err
+	}
+	publisher.ConsumerPort = p.Consumer.Addr().(*net.TCPAddr).Port
+	if p.Publisher, err = start(publisher); err != nil {
+		p.Consumer.Shutdown()
+		return nil, err
+	}
+	return p, nil
+}
+
+// start starts a server on a port chosen by the system, returning once it is listening.
+func start(opts *server.Options) (*server.Server, error) {
+	opts.Port = 0
+	s := server.New(opts)
+	failed := make(chan error, 1)
+	go func() {
+		if err := s.Start(); err != nil {
+			failed <- err
+		}
+	}()
+	deadline := time.Now().Add(startTimeout)
+	for s.Addr() == nil {
+		select {
+		case err := <-failed:
+			return nil, err
+		case <-time.After(pollInterval):
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("servertest: server did not start listening")
+		}
+	}
+	return s, nil
+}
+
+// Close shuts down the publisher, draining its ring to the consumer, then the consumer.
+func (p *Pair) Close() {
+	p.Publisher.Shutdown()
+	p.Consumer.Shutdown()
+}
+
+// Stored returns the values the consumer has stored, sorted, as workers forward them in no
+// particular order.
+func (p *Pair) Stored() []int {
+	ms, ok := p.Consumer.Store().(*server.MemoryStore)
+	if !ok {
+		return nil
+	}
+	v := ms.Values()
+	sort.Ints(v)
+	return v
+}
+
+// WaitStored waits up to timeout for the consumer to store n values, returning them sorted.
+func (p *Pair) WaitStored(n int, timeout time.Duration) ([]int, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		v := p.Stored()
+		if len(v) >= n {
+			return v, nil
+		}
+		if time.Now().After(deadline) {
+			return v, fmt.Errorf("servertest: consumer stored %d of %d values", len(v), n)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Stats returns the statistics s reports on its stats route.
+func Stats(s *server.Server) (*server.Stats, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s/v1.0/stats", s.Addr()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("servertest: stats returned %s", resp.Status)
+	}
+	body := &struct {
+		Stats *server.Stats `json:"stats"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
+		return nil, err
+	}
+	return body.Stats, nil
+}
+
+// Client is a websocket connection to a server's ingest route.
+type Client struct {
+	ws  *websocket.Conn // The socket to the server.
+	seq uint64          // Sequence of the next value sent.
+}
+
+// Dial opens a Client to the ingest route of s.
+func Dial(s *server.Server) (*Client, error) {
+	addr := s.Addr().String()
+	ws, err := websocket.Dial(fmt.Sprintf("ws://%s/v1.0/ingest", addr), "", fmt.Sprintf("http://%s/", addr))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{ws: ws, seq: 1}, nil
+}
+
+// Send sends the values in a Data frame if one, else a Batch frame, and returns the reply.
+func (c *Client) Send(values ...int32) (*protocol.Frame, error) {
+	var f *protocol.Frame
+	if len(values) == 1 {
+		f = protocol.DataFrameNew(c.seq, values[0])
+	} else {
+		f = protocol.BatchFrameNew(c.seq, values)
+	}
+	c.seq += uint64(len(values))
+	return c.SendRaw(protocol.Encode(f))
+}
+
+// SendRaw sends req as is and returns the reply.
+func (c *Client) SendRaw(req []byte) (*protocol.Frame, error) {
+	c.ws.SetDeadline(time.Now().Add(startTimeout))
+	if err := websocket.Message.Send(c.ws, req); err != nil {
+		return nil, err
+	}
+	var resp []byte
+	if err := websocket.Message.Receive(c.ws, &resp); err != nil {
+		return nil, err
+	}
+	return protocol.Decode(resp)
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.ws.Close()
+}
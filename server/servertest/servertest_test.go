@@ -0,0 +1,128 @@
+package servertest
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/composer22/ringoexp/protocol"
+	"github.com/composer22/ringoexp/server"
+)
+
+// testPair starts a pair of servers, closing them when the test ends.
+func testPair(t *testing.T, configure func(publisher, consumer *server.Options)) *Pair {
+	p, err := PairNew(configure)
+	if err != nil {
+		t.Fatalf("Cannot start servers: %s", err.Error())
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestPairForward(t *testing.T) {
+	p := testPair(t, nil)
+
+	// Two clients each send single values and a batch.
+	var wg sync.WaitGroup
+	for c := 0; c < 2; c++ {
+		wg.Add(1)
+		go func(c int32) {
+			defer wg.Done()
+			cl, err := Dial(p.Publisher)
+			if err != nil {
+				t.Errorf("Cannot dial publisher: %s", err.Error())
+				return
+			}
+			defer cl.Close()
+			sends := [][]int32{{c*100 + 1}, {c*100 + 2}, {c*100 + 3, c*100 + 4, c*100 + 5}}
+			for n, values := range sends {
+				f, err := cl.Send(values...)
+				if err != nil || f.Type != protocol.TypeAck || f.Seq != []uint64{1, 2, 5}[n] {
+					t.Errorf("Client %d send %v should be acked, received %s %v.", c, values, f, err)
+				}
+			}
+		}(int32(c))
+	}
+	wg.Wait()
+
+	stored, err := p.WaitStored(10, 5*time.Second)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if expected := []int{1, 2, 3, 4, 5, 101, 102, 103, 104, 105}; !reflect.DeepEqual(stored, expected) {
+		t.Errorf("Consumer stored incorrect values.\n\nExpected: %v\n\nActual: %v\n", expected, stored)
+	}
+
+	// The publisher counts what clients sent and what it forwarded, the consumer what it stored.
+	pub, err := Stats(p.Publisher)
+	if err != nil {
+		t.Fatalf("Cannot get publisher stats: %s", err.Error())
+	}
+	if pub.Received != 10 || pub.Acked != 10 || pub.Forwarded != 10 || pub.Reserves != 6 {
+		t.Errorf("Publisher stats incorrect: %+v", pub)
+	}
+	con, err := Stats(p.Consumer)
+	if err != nil {
+		t.Fatalf("Cannot get consumer stats: %s", err.Error())
+	}
+	if con.Received != 10 || con.Stored != 10 || con.WorkerConns != 0 {
+		t.Errorf("Consumer stats incorrect: %+v", con)
+	}
+}
+
+func TestPairErrors(t *testing.T) {
+	p := testPair(t, func(publisher, consumer *server.Options) { publisher.RingSize = 4 })
+	cl, err := Dial(p.Publisher)
+	if err != nil {
+		t.Fatalf("Cannot dial publisher: %s", err.Error())
+	}
+	defer cl.Close()
+
+	tests := []struct {
+		name string
+		send func() (*protocol.Frame, error)
+		code uint16
+	}{
+		{"too large", func() (*protocol.Frame, error) { return cl.Send(1, 2, 3, 4, 5) }, protocol.CodeTooLarge},
+		{"malformed", func() (*protocol.Frame, error) { return cl.SendRaw([]byte{1, 2, 3}) }, protocol.CodeMalformed},
+		{"ack", func() (*protocol.Frame, error) {
+			return cl.SendRaw(protocol.Encode(protocol.AckFrameNew(1)))
+		}, protocol.CodeType},
+	}
+	for _, tc := range tests {
+		f, err := tc.send()
+		if err != nil {
+			t.Fatalf("Send %s failed: %s", tc.name, err.Error())
+		}
+		if code, _, _ := f.Error(); f.Type != protocol.TypeError || code != tc.code {
+			t.Errorf("Send %s should reply error %d, received %s.", tc.name, tc.code, f)
+		}
+	}
+
+	// The connection is still usable, and a full ring's worth is accepted.
+	if f, err := cl.Send(1, 2, 3, 4); err != nil || f.Type != protocol.TypeAck {
+		t.Errorf("Send after errors should be acked, received %s %v.", f, err)
+	}
+	if _, err := p.WaitStored(4, 5*time.Second); err != nil {
+		t.Errorf("%s", err.Error())
+	}
+}
+
+func TestPairJournal(t *testing.T) {
+	dir := t.TempDir()
+	p := testPair(t, func(publisher, consumer *server.Options) { publisher.JournalDir = dir })
+	cl, err := Dial(p.Publisher)
+	if err != nil {
+		t.Fatalf("Cannot dial publisher: %s", err.Error())
+	}
+	defer cl.Close()
+	for v := int32(1); v <= 20; v++ {
+		if f, err := cl.Send(v); err != nil || f.Type != protocol.TypeAck {
+			t.Fatalf("Send %d should be acked, received %s %v.", v, f, err)
+		}
+	}
+	if stored, err := p.WaitStored(20, 5*time.Second); err != nil || stored[19] != 20 {
+		t.Errorf("Journaling publisher should forward every value: %v %v", stored, err)
+	}
+}
@@ -2,19 +2,43 @@ package server
 
 import (
 	"encoding/json"
+	"sync/atomic"
 	"time"
+
+	"github.com/composer22/ringoexp/ringbuffer"
 )
 
-// Stats contains runtime statistics for the server.
+// Stats contains runtime statistics for the server. Counters and gauges are updated lock-free
+// with the sync/atomic package and read as a snapshot when marshalled. Latency histograms are
+// only exported on the metrics route.
 type Stats struct {
-	Start time.Time `json:"startTime"` // The start time of the server.
+	Start            time.Time           `json:"startTime"`         // The start time of the server.
+	Received         int64               `json:"received"`          // Values received from ingest clients.
+	Acked            int64               `json:"acked"`             // Values acknowledged to ingest clients.
+	Busy             int64               `json:"busy"`              // Values answered busy as the ring was full.
+	Forwarded        int64               `json:"forwarded"`         // Values forwarded by workers to the consumer.
+	Stored           int64               `json:"stored"`            // Values written to the store.
+	BytesIn          int64               `json:"bytesIn"`           // Bytes received on websockets.
+	BytesOut         int64               `json:"bytesOut"`          // Bytes sent on websockets.
+	RingPeak         int64               `json:"ringPeak"`          // Highest ring occupancy seen.
+	Reserves         int64               `json:"reserves"`          // Reserves made by ingest clients on the ring.
+	ReserveWaitNs    int64               `json:"reserveWaitNs"`     // Total time spent in those reserves.
+	IngestConns      int64               `json:"ingestConnections"` // Active ingest connections.
+	WorkerConns      int64               `json:"workerConnections"` // Active worker connections to the consumer.
+	IngestAckLatency *Histogram          `json:"-"`                 // Time from an ingest frame arriving to its ack being sent.
+	WorkerAckLatency *Histogram          `json:"-"`                 // Time from a worker sending a value to the consumer ack.
+	StoreLatency     *Histogram          `json:"-"`                 // Time taken by each write to the store.
+	ring             *ringbuffer.Manager // The ring to report occupancy of, if any.
 }
 
 // StatsNew is a factory function that returns a new instance of statistics.
 // options is an optional list of functions that initialize the structure
 func StatsNew(opts ...func(*Stats)) *Stats {
 	s := &Stats{
-		Start: time.Now(),
+		Start:            time.Now(),
+		IngestAckLatency: HistogramNew(),
+		WorkerAckLatency: HistogramNew(),
+		StoreLatency:     HistogramNew(),
 	}
 	for _, f := range opts {
 		f(s)
@@ -22,6 +46,67 @@ func StatsNew(opts ...func(*Stats)) *Stats {
 	return s
 }
 
+// Add atomically adds delta to a counter or gauge of the stats, such as &s.Received.
+func (s *Stats) Add(field *int64, delta int64) {
+	atomic.AddInt64(field, delta)
+}
+
+// ReserveWait records the time spent on one reserve against the ring.
+func (s *Stats) ReserveWait(d time.Duration) {
+	atomic.AddInt64(&s.Reserves, 1)
+	atomic.AddInt64(&s.ReserveWaitNs, int64(d))
+}
+
+// Occupancy returns the current ring occupancy and raises the peak if it has been passed.
+func (s *Stats) Occupancy() int64 {
+	if s.ring == nil {
+		return 0
+	}
+	occ := s.ring.Occupancy()
+	for {
+		peak := atomic.LoadInt64(&s.RingPeak)
+		if occ <= peak || atomic.CompareAndSwapInt64(&s.RingPeak, peak, occ) {
+			return occ
+		}
+	}
+}
+
+// MarshalJSON returns a consistent snapshot of the stats as json.
+func (s *Stats) MarshalJSON() ([]byte, error) {
+	occ := s.Occupancy()
+	return json.Marshal(&struct {
+		Start         time.Time `json:"startTime"`
+		Received      int64     `json:"received"`
+		Acked         int64     `json:"acked"`
+		Busy          int64     `json:"busy"`
+		Forwarded     int64     `json:"forwarded"`
+		Stored        int64     `json:"stored"`
+		BytesIn       int64     `json:"bytesIn"`
+		BytesOut      int64     `json:"bytesOut"`
+		Ring          int64     `json:"ring"`
+		RingPeak      int64     `json:"ringPeak"`
+		Reserves      int64     `json:"reserves"`
+		ReserveWaitNs int64     `json:"reserveWaitNs"`
+		IngestConns   int64     `json:"ingestConnections"`
+		WorkerConns   int64     `json:"workerConnections"`
+	}{
+		Start:         s.Start,
+		Received:      atomic.LoadInt64(&s.Received),
+		Acked:         atomic.LoadInt64(&s.Acked),
+		Busy:          atomic.LoadInt64(&s.Busy),
+		Forwarded:     atomic.LoadInt64(&s.Forwarded),
+		Stored:        atomic.LoadInt64(&s.Stored),
+		BytesIn:       atomic.LoadInt64(&s.BytesIn),
+		BytesOut:      atomic.LoadInt64(&s.BytesOut),
+		Ring:          occ,
+		RingPeak:      atomic.LoadInt64(&s.RingPeak),
+		Reserves:      atomic.LoadInt64(&s.Reserves),
+		ReserveWaitNs: atomic.LoadInt64(&s.ReserveWaitNs),
+		IngestConns:   atomic.LoadInt64(&s.IngestConns),
+		WorkerConns:   atomic.LoadInt64(&s.WorkerConns),
+	})
+}
+
 // String is an implentation of the Stringer interface so the structure is returned as a
 // string to fmt.Print() etc.
 func (s *Stats) String() string {
@@ -3,12 +3,18 @@ package server
 import (
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/composer22/ringoexp/ringbuffer"
 )
 
 const (
-	testStatsExpectedJSONResult = `{"startTime":"2006-01-02T13:24:56Z"}`
+	testStatsExpectedJSONResult = `{"startTime":"2006-01-02T13:24:56Z","received":0,"acked":0,` +
+		`"busy":0,"forwarded":0,"stored":0,"bytesIn":0,"bytesOut":0,"ring":0,"ringPeak":0,"reserves":0,` +
+		`"reserveWaitNs":0,"ingestConnections":0,"workerConnections":0}`
 )
 
 func TestStatsNew(t *testing.T) {
@@ -43,3 +49,38 @@ func TestStatString(t *testing.T) {
 			testStatsExpectedJSONResult, actual)
 	}
 }
+
+func TestStatsCounters(t *testing.T) {
+	t.Parallel()
+	rm := ringbuffer.ManagerNew(8)
+	s := StatsNew(func(sts *Stats) {
+		sts.ring = rm
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				s.Add(&s.Received, 2)
+				s.ReserveWait(time.Microsecond)
+			}
+		}()
+	}
+	wg.Wait()
+	if s.Received != 800 || s.Reserves != 400 || s.ReserveWaitNs != int64(400*time.Microsecond) {
+		t.Errorf("Stats counters lost updates. Received %s.", s)
+	}
+
+	j := rm.Leader.Reserve(5)
+	rm.Leader.Commit(j-4, j)
+	if occ := s.Occupancy(); occ != 5 || s.RingPeak != 5 {
+		t.Errorf("Stats occupancy incorrect. Received %d peak %d.", occ, s.RingPeak)
+	}
+	j = rm.Follower.Reserve(3)
+	rm.Follower.Commit(j-2, j)
+	if !strings.Contains(s.String(), `"ring":2,"ringPeak":5`) {
+		t.Errorf("Stats should report current occupancy and keep the peak. Received %s.", s)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Store is where a consumer server keeps the work it receives.
+type Store interface {
+	Write(values ...int) error // Stores the values.
+	Flush() error              // Forces any buffered values to be stored.
+	Close() error              // Flushes and releases the store.
+}
+
+// StoreNew is a factory function that returns a new Store of type tp ("memory" or "file").
+// If batch > 1, writes are buffered and sent to the store in batches of that size. Buffered
+// values are acked before they reach the store, so batching trades a crash losing them for
+// fewer, larger writes.
+func StoreNew(tp string, path string, batch int) (Store, error) {
+	var st Store
+	switch tp {
+	case StoreMemory:
+		st = MemoryStoreNew()
+	case StoreFile:
+		fs, err := FileStoreNew(path)
+		if err != nil {
+			return nil, err
+		}
+		st = fs
+	default:
+		return nil, fmt.Errorf("Unknown store type %q.", tp)
+	}
+	if batch > 1 {
+		st = BatchStoreNew(st, batch, storeFlushInterval)
+	}
+	return st, nil
+}
+
+// MemoryStore keeps values in memory. Useful for testing and benchmarking the consumer.
+type MemoryStore struct {
+	mu     sync.Mutex // For locking access to values.
+	values []int      // Everything stored.
+}
+
+// MemoryStoreNew is a factory function that returns a new MemoryStore instance.
+func MemoryStoreNew() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Write appends the values.
+func (m *MemoryStore) Write(values ...int) error {
+	m.mu.Lock()
+	m.values = append(m.values, values...)
+	m.mu.Unlock()
+	return nil
+}
+
+// Flush is a no-op.
+func (m *MemoryStore) Flush() error { return nil }
+
+// Close is a no-op.
+func (m *MemoryStore) Close() error { return nil }
+
+// Values returns a copy of everything stored.
+func (m *MemoryStore) Values() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int(nil), m.values...)
+}
+
+// FileStore appends values to a file as 8 byte big endian integers.
+type FileStore struct {
+	mu   sync.Mutex // For locking access to the file.
+	file *os.File   // The append only file.
+	size int64      // The length of the file after the last good write.
+	torn bool       // A failed write may have left part of its values in the file.
+}
+
+// FileStoreNew is a factory function that returns a new FileStore appending to path.
+func FileStoreNew(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileStore{
+		file: f,
+		size: fi.Size(),
+	}, nil
+}
+
+// Write appends the values to the file in a single write. Whatever a failed write left in the
+// file is cut off again, so the values aren't stored twice when the caller retries.
+func (f *FileStore) Write(values ...int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.torn {
+		if err := f.file.Truncate(f.size); err != nil {
+			return err
+		}
+		f.torn = false
+	}
+	b := make([]byte, 8*len(values))
+	for j, v := range values {
+		binary.BigEndian.PutUint64(b[8*j:], uint64(v))
+	}
+	n, err := f.file.Write(b)
+	if err != nil {
+		f.torn = f.file.Truncate(f.size) != nil // Tried again on the next write if it fails.
+		return err
+	}
+	f.size += int64(n)
+	return nil
+}
+
+// Flush is a no-op as every Write() reaches the file.
+func (f *FileStore) Flush() error { return nil }
+
+// Close closes the file.
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// BatchStore buffers values and writes them to another Store in batches, so many small
+// writes from many connections become few large ones. Anything buffered is written when a
+// Write() fills the batch, on Flush(), on Close(), or when interval passes. A Write() holding more
+// than a batch writes it all at once, so a failure never leaves part of its values stored. A
+// batch the store fails to write stays buffered and is tried again, and the failure is returned
+// by the next Write().
+type BatchStore struct {
+	mu    sync.Mutex     // For locking access to buff and err.
+	store Store          // Where batches are written.
+	batch int            // The number of values per batch.
+	buff  []int          // Values waiting on a batch to fill, or on a failed write to be retried.
+	err   error          // Why the last write to the store failed, nil once one succeeds.
+	done  chan bool      // Channel to stop the flush timer.
+	wg    sync.WaitGroup // Synchronization of the flush timer stopping.
+}
+
+// BatchStoreNew is a factory function that returns a new BatchStore writing batch values at a
+// time to s.
+func BatchStoreNew(s Store, batch int, interval time.Duration) *BatchStore {
+	b := &BatchStore{
+		store: s,
+		batch: batch,
+		buff:  make([]int, 0, batch),
+		done:  make(chan bool),
+	}
+	b.wg.Add(1)
+	go b.flushTimer(interval)
+	return b
+}
+
+// Write buffers the values, writing to the store if the batch fills. If the store is failing,
+// the values are dropped from the buffer and the error returned, so the caller doesn't ack them.
+// Values buffered by earlier writes are kept for the retry.
+func (b *BatchStore) Write(values ...int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil { // Retry what a failed write left before taking more.
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	kept := len(b.buff)
+	b.buff = append(b.buff, values...)
+	if len(b.buff) >= b.batch {
+		if err := b.flush(); err != nil {
+			b.buff = b.buff[:kept]
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes anything buffered to the store.
+func (b *BatchStore) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush()
+}
+
+// Close stops the flush timer, flushes and closes the store.
+func (b *BatchStore) Close() error {
+	close(b.done)
+	b.wg.Wait()
+	if err := b.Flush(); err != nil {
+		b.store.Close()
+		return err
+	}
+	return b.store.Close()
+}
+
+// flush writes the buffer to the store, keeping it if the write fails. The caller must hold
+// the lock.
+func (b *BatchStore) flush() error {
+	if len(b.buff) == 0 {
+		return nil
+	}
+	if b.err = b.store.Write(b.buff...); b.err != nil {
+		return b.err
+	}
+	b.buff = b.buff[:0]
+	return nil
+}
+
+// flushTimer is a go routine that periodically flushes a part filled batch. A failure is kept
+// for the next Write() to return.
+func (b *BatchStore) flushTimer(interval time.Duration) {
+	defer b.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-t.C:
+			b.Flush()
+		}
+	}
+}
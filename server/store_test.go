@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreNew(t *testing.T) {
+	t.Parallel()
+	st, err := StoreNew(StoreMemory, "", 1)
+	if _, ok := st.(*MemoryStore); err != nil || !ok {
+		t.Errorf("Expected an unbatched MemoryStore, received %T %v.", st, err)
+	}
+	st, err = StoreNew(StoreMemory, "", 8)
+	if _, ok := st.(*BatchStore); err != nil || !ok {
+		t.Errorf("Expected a BatchStore, received %T %v.", st, err)
+	}
+	st.Close()
+	if _, err = StoreNew("bogus", "", 1); err == nil {
+		t.Errorf("Unknown store type should return an error.")
+	}
+	if _, err = StoreNew(StoreFile, filepath.Join(t.TempDir(), "no", "such", "dir"), 1); err == nil {
+		t.Errorf("Unwritable store file should return an error.")
+	}
+}
+
+func TestStoreMemory(t *testing.T) {
+	t.Parallel()
+	m := MemoryStoreNew()
+	m.Write(1, 2)
+	m.Write(3)
+	if actual := m.Values(); !reflect.DeepEqual(actual, []int{1, 2, 3}) {
+		t.Errorf("MemoryStore values incorrect.\n\nExpected: [1 2 3]\n\nActual: %v\n", actual)
+	}
+}
+
+func TestStoreFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "store.dat")
+	for _, vals := range [][]int{{1, -2}, {300}} {
+		f, err := FileStoreNew(path)
+		if err != nil {
+			t.Fatalf("Couldn't open FileStore: %s", err)
+		}
+		if err = f.Write(vals...); err != nil {
+			t.Fatalf("Couldn't write FileStore: %s", err)
+		}
+		f.Close()
+	}
+
+	b, _ := os.ReadFile(path)
+	if len(b) != 24 {
+		t.Fatalf("FileStore should have appended 24 bytes, found %d.", len(b))
+	}
+	var actual []int
+	for i := 0; i < len(b); i += 8 {
+		actual = append(actual, int(int64(binary.BigEndian.Uint64(b[i:]))))
+	}
+	if !reflect.DeepEqual(actual, []int{1, -2, 300}) {
+		t.Errorf("FileStore values incorrect.\n\nExpected: [1 -2 300]\n\nActual: %v\n", actual)
+	}
+}
+
+func TestStoreFileError(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "store.dat")
+	f, err := FileStoreNew(path)
+	if err != nil {
+		t.Fatalf("Couldn't open FileStore: %s", err)
+	}
+	defer f.Close()
+	f.Write(1)
+
+	// A failed write that leaves part of its values behind, as on a full disk.
+	good := f.file
+	f.file, _ = os.Open(path)
+	if err = f.Write(2, 3); err == nil {
+		t.Fatalf("FileStore should return the write error.")
+	}
+	f.file.Close()
+	f.file = good
+	good.Write([]byte{0, 0, 0})
+
+	// The write is retried without storing the partial values, and later writes aren't failed.
+	for _, vals := range [][]int{{2, 3}, {4}} {
+		if err = f.Write(vals...); err != nil {
+			t.Fatalf("FileStore should write once the file recovers: %s", err)
+		}
+	}
+	b, _ := os.ReadFile(path)
+	var actual []int
+	for i := 0; i+8 <= len(b); i += 8 {
+		actual = append(actual, int(int64(binary.BigEndian.Uint64(b[i:]))))
+	}
+	if len(b) != 32 || !reflect.DeepEqual(actual, []int{1, 2, 3, 4}) {
+		t.Errorf("FileStore values incorrect.\n\nExpected: [1 2 3 4]\n\nActual: %v %d bytes\n", actual, len(b))
+	}
+}
+
+func TestStoreBatch(t *testing.T) {
+	t.Parallel()
+	m := MemoryStoreNew()
+	b := BatchStoreNew(m, 3, time.Hour)
+	b.Write(1, 2)
+	if len(m.Values()) != 0 {
+		t.Fatalf("BatchStore wrote before the batch filled.")
+	}
+	b.Write(3, 4)
+	if actual := m.Values(); !reflect.DeepEqual(actual, []int{1, 2, 3, 4}) {
+		t.Fatalf("BatchStore should write a full batch.\n\nExpected: [1 2 3 4]\n\nActual: %v\n", actual)
+	}
+	b.Write(5)
+	b.Close()
+	if actual := m.Values(); !reflect.DeepEqual(actual, []int{1, 2, 3, 4, 5}) {
+		t.Fatalf("BatchStore should flush on close.\n\nExpected: [1 2 3 4 5]\n\nActual: %v\n", actual)
+	}
+}
+
+// testFlakyStore is a MemoryStore whose writes fail while fail is set.
+type testFlakyStore struct {
+	MemoryStore
+	fail bool
+}
+
+func (s *testFlakyStore) Write(values ...int) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.MemoryStore.Write(values...)
+}
+
+func TestStoreBatchError(t *testing.T) {
+	t.Parallel()
+	m := &testFlakyStore{}
+	b := BatchStoreNew(m, 3, time.Hour)
+	defer b.Close()
+	b.Write(1, 2)
+
+	// The failed batch is kept, and the values of the failed write dropped so they aren't acked.
+	m.fail = true
+	if err := b.Write(3, 4); err == nil {
+		t.Fatalf("BatchStore should return the store error.")
+	}
+	if err := b.Flush(); err == nil {
+		t.Errorf("BatchStore flush should return the store error.")
+	}
+	if err := b.Write(5); err == nil {
+		t.Errorf("BatchStore should fail writes until the store recovers.")
+	}
+	m.fail = false
+	if err := b.Write(5); err != nil {
+		t.Fatalf("BatchStore should write once the store recovers: %s", err)
+	}
+	b.Flush()
+	if actual := m.Values(); !reflect.DeepEqual(actual, []int{1, 2, 5}) {
+		t.Errorf("BatchStore should keep what it accepted.\n\nExpected: [1 2 5]\n\nActual: %v\n", actual)
+	}
+}
+
+// testSecondFailStore is a MemoryStore whose second write fails.
+type testSecondFailStore struct {
+	MemoryStore
+	writes int
+}
+
+func (s *testSecondFailStore) Write(values ...int) error {
+	if s.writes++; s.writes == 2 {
+		return errors.New("disk full")
+	}
+	return s.MemoryStore.Write(values...)
+}
+
+func TestStoreBatchPartial(t *testing.T) {
+	t.Parallel()
+	m := &testSecondFailStore{}
+	b := BatchStoreNew(m, 2, time.Hour)
+	b.Write(1)
+
+	// A write filling more than one batch either stores all its values or none, so the
+	// caller's retry of a failure doesn't store any twice.
+	for _, vals := range [][]int{{2, 3, 4, 5}, {6, 7}} {
+		if err := b.Write(vals...); err != nil {
+			if err = b.Write(vals...); err != nil {
+				t.Fatalf("BatchStore should write once the store recovers: %s", err)
+			}
+		}
+	}
+	b.Close()
+	if actual, expected := m.Values(), []int{1, 2, 3, 4, 5, 6, 7}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("BatchStore values incorrect.\n\nExpected: %v\n\nActual: %v\n", expected, actual)
+	}
+}
+
+func TestStoreBatchInterval(t *testing.T) {
+	t.Parallel()
+	m := MemoryStoreNew()
+	b := BatchStoreNew(m, 100, 10*time.Millisecond)
+	defer b.Close()
+	b.Write(1)
+	for i := 0; i < 100 && len(m.Values()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(m.Values()) != 1 {
+		t.Fatalf("BatchStore should flush a part filled batch on the interval.")
+	}
+}
@@ -9,6 +9,7 @@ const usageText = `
 Description: is a small server that demonstrates ring-buffer usage as a means to optimize memory and processing usage.
 
 Usage: ringoexp [options...]
+       ringoexp bench [bench options...]
 
 Basic Server options:
     -N, --name NAME					NAME of the server (default: empty).
@@ -20,8 +21,19 @@ Basic Server options:
 Publisher Server Mode - additional options (is_publisher = true):
     -r, --ring_size SIZE			    SIZE of the incoming ring buffer (default: 4096).
     -U, --consumer_hostname HOSTNAME	HOSTNAME of the remote consumer server (default: localhost).
-    -T, --consumer_port PORT			PORT of the remote consumer server (default: 6660).
+    -T, --consumer_port PORT			PORT of the remote consumer server (default: 6661).
     -W, --workers MAX         			MAX worker connections to the consumer (default: 1024).
+    -w, --wait_strategy NAME			NAME of how the ring waits when full or empty (default: blocking).
+    									busyspin | yielding | sleeping | blocking
+    -B, --busy_wait MS				MS ingest waits on a full ring before replying busy, 0 = never: drop the client after 5s (default: 100).
+    -D, --drain_timeout SECS			SECS to forward work left in the ring on shutdown, 0 = abandon it (default: 30).
+    -j, --journal_dir PATH			PATH of a directory journaling the ring so a crash loses no work (default: off).
+
+Consumer Server Mode - additional options (is_publisher = false):
+    -s, --store TYPE					TYPE of store for work received: memory | file (default: memory).
+    -F, --store_file PATH				PATH of the file work is appended to if store is file (default: ringoexp.dat).
+    -b, --store_batch SIZE			SIZE of batched writes to the store, 1 = unbatched (default: 1).
+    									Batched values are acked before they are stored, so a crash can lose them.
 
 System level options:
 	-X, --procs MAX                  *MAX processor cores to use from the machine.
@@ -30,10 +42,25 @@ System level options:
 
      *  Anything <= 0 is no change to the environment (default: 0).
 
+Configuration options:
+    -c, --config FILE                FILE of options: .json | .yaml | .yml | .toml (default: none).
+
+     Options are layered: flags > environment > config file > defaults. Any option may be set in
+     the environment as RINGOEXP_ plus its config key in upper snake case, e.g. RINGOEXP_RING_SIZE.
+
 Common options:
     -h, --help                       Show this message
     -V, --version                    Show version
 
+Bench options (load test a running server):
+    -u, --url URL                    URL of the ingest endpoint (default: ws://localhost:6660/v1.0/ingest).
+    -n, --connections MAX            MAX websocket connections to open (default: 8).
+    -b, --batch SIZE                 SIZE values per frame, 1 = Data frames (default: 1).
+    -w, --window MAX                 MAX frames in flight per connection before waiting on acks (default: 64).
+    -R, --rate RATE                  RATE of values per second across all connections (default: unlimited).
+    -m, --count COUNT                COUNT of values to send (default: send for the duration).
+    -d, --duration TIME              TIME to send for, such as 30s or 5m (default: 10s).
+
 Examples:
 
 	# Publisher Mode:
@@ -55,6 +82,22 @@ Examples:
 	# Is Publisher: false
 
 	ringoexp -N "San Francisco" -H 0.0.0.0 -p 6662 -n 1280 -I false
+
+	# Consumer Mode storing to a file:
+	#
+	# Port: 6662
+	# Is Publisher: false
+	# Store: /var/lib/ringoexp/work.dat
+
+	ringoexp -p 6662 -I false -s file -F /var/lib/ringoexp/work.dat
+
+	# Publisher Mode from a config file, overriding the port from the environment:
+
+	RINGOEXP_PORT=6663 ringoexp -c /etc/ringoexp/publisher.yaml
+
+	# Load test a publisher on port 6661 for a minute, 16 connections sending batches of 64:
+
+	ringoexp bench -u ws://localhost:6661/v1.0/ingest -n 16 -b 64 -d 1m
 `
 
 // end help text
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/composer22/ringoexp/protocol"
+	"github.com/composer22/ringoexp/ringbuffer"
+	"golang.org/x/net/websocket"
+)
+
+// Worker is a background task that forwards work from the ringbuffer to a remote consumer server.
+type Worker struct {
+	id     int                 // The worker id in the pool.
+	url    string              // The ingest endpoint of the remote consumer server.
+	origin string              // The origin sent to the consumer on connect.
+	ws     *websocket.Conn     // The socket to the consumer.
+	rb     []int               // Ringbuffer for the data.
+	rm     *ringbuffer.Manager // Synchronizer for work.
+	ctx    context.Context     // Cancelled by the server to signal the worker should close down.
+	idle   context.Context     // Cancelled by Stop(), or with ctx, to end the worker between values.
+	stop   context.CancelFunc  // Cancels idle.
+	stats  *Stats              // Server statistics to update.
+	log    *RingoExpLogger     // Log file out.
+	swg    *sync.WaitGroup     // Server synchronization of server close.
+}
+
+// WorkerNew is a factory function that returns a new Worker instance.
+func WorkerNew(id int, host string, port int, r []int, m *ringbuffer.Manager, ctx context.Context,
+	st *Stats, l *RingoExpLogger, swg *sync.WaitGroup) *Worker {
+	w := &Worker{
+		id:     id,
+		url:    fmt.Sprintf("ws://%s:%d%s", host, port, wsRouteV1Ingest),
+		origin: fmt.Sprintf("http://%s/", host),
+		rb:     r,
+		rm:     m,
+		ctx:    ctx,
+		stats:  st,
+		log:    l,
+		swg:    swg,
+	}
+	w.idle, w.stop = context.WithCancel(ctx)
+	return w
+}
+
+// Run starts the event loop that reads work from the ringbuffer and forwards it to the consumer.
+// A slot is only committed back to the ring after the consumer has acknowledged it.
+// The caller should add the worker to the server wait group before spawning Run().
+func (w *Worker) Run() {
+	defer w.swg.Done()
+	defer w.disconnect()
+	mask := w.rm.Follower.Mask()
+	for {
+		indx, err := w.rm.Follower.ReserveContext(w.idle, 1)
+		if err != nil {
+			return
+		}
+		if !w.forward(indx, w.rb[indx&mask]) {
+			return
+		}
+		w.rm.Follower.Commit(indx, indx)
+	}
+}
+
+// Stop ends the worker once it has forwarded the value in hand, if any, so nothing it reserved
+// from the ring is left uncommitted.
+func (w *Worker) Stop() {
+	w.stop()
+}
+
+// forward sends a value to the consumer and waits on the ack, reconnecting as needed.
+// It returns false only if the server requested the worker to quit.
+func (w *Worker) forward(seq int64, value int) bool {
+	msg := protocol.Encode(protocol.DataFrameNew(uint64(seq), int32(value)))
+	var resp []byte
+	for {
+		if w.ctx.Err() != nil || (w.ws == nil && !w.connect()) {
+			return false
+		}
+		start := time.Now()
+		w.ws.SetDeadline(start.Add(workerAckTimeout))
+		err := websocket.Message.Send(w.ws, msg)
+		if err == nil {
+			w.stats.Add(&w.stats.BytesOut, int64(len(msg)))
+			err = websocket.Message.Receive(w.ws, &resp)
+		}
+		if err == nil {
+			w.stats.Add(&w.stats.BytesIn, int64(len(resp)))
+			err = checkAck(resp, uint64(seq))
+		}
+		if err == nil {
+			w.stats.WorkerAckLatency.Observe(time.Since(start))
+			w.stats.Add(&w.stats.Forwarded, 1)
+			return true
+		}
+		w.log.Errorf("Worker %d couldn't forward to %s. Error: %s", w.id, w.url, err.Error())
+		w.disconnect()
+		select {
+		case <-w.ctx.Done():
+			return false
+		case <-time.After(workerRedialDelay):
+		}
+	}
+}
+
+// checkAck validates the consumer's reply acknowledges seq. Acks are cumulative, so any Ack at or
+// above seq covers it.
+func checkAck(resp []byte, seq uint64) error {
+	f, err := protocol.Decode(resp)
+	switch {
+	case err != nil:
+		return err
+	case f.Type == protocol.TypeError:
+		code, msg, _ := f.Error()
+		return fmt.Errorf("consumer replied error %d: %s", code, msg)
+	case f.Type != protocol.TypeAck || f.Seq < seq:
+		return fmt.Errorf("unexpected reply %s to sequence %d", f, seq)
+	}
+	return nil
+}
+
+// connect dials the consumer, retrying until it succeeds or the server requests a quit.
+func (w *Worker) connect() bool {
+	for {
+		ws, err := websocket.Dial(w.url, "", w.origin)
+		if err == nil {
+			w.log.Debugf("Worker %d connected to %s.", w.id, w.url)
+			w.stats.Add(&w.stats.WorkerConns, 1)
+			w.ws = ws
+			return true
+		}
+		w.log.Errorf("Worker %d couldn't connect to %s. Error: %s", w.id, w.url, err.Error())
+		select {
+		case <-w.ctx.Done():
+			return false
+		case <-time.After(workerRedialDelay):
+		}
+	}
+}
+
+// disconnect closes the socket to the consumer if open.
+func (w *Worker) disconnect() {
+	if w.ws != nil {
+		w.ws.Close()
+		w.ws = nil
+		w.stats.Add(&w.stats.WorkerConns, -1)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/composer22/ringoexp/protocol"
+	"github.com/composer22/ringoexp/ringbuffer"
+	"golang.org/x/net/websocket"
+)
+
+func TestWorkerForward(t *testing.T) {
+	t.Parallel()
+	received := make(chan int, 8)
+	consumer := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var req []byte
+		for websocket.Message.Receive(ws, &req) == nil {
+			f, _ := protocol.Decode(req)
+			v, _ := f.Value()
+			received <- int(v)
+			websocket.Message.Send(ws, protocol.Encode(protocol.AckFrameNew(f.Seq)))
+		}
+	}))
+	defer consumer.Close()
+	host, p, _ := net.SplitHostPort(consumer.Listener.Addr().String())
+	port, _ := strconv.Atoi(p)
+
+	ringSize := int64(8)
+	rb := make([]int, ringSize)
+	rm := ringbuffer.ManagerNew(ringSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	st := StatsNew()
+	go WorkerNew(0, host, port, rb, rm, ctx, st, RingoExpLoggerNew(), &wg).Run()
+
+	mask := rm.Leader.Mask()
+	for i := 1; i <= 3; i++ {
+		indx := rm.Leader.Reserve(1)
+		rb[indx&mask] = i * 11
+		rm.Leader.Commit(indx, indx)
+	}
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case v := <-received:
+			if v != i*11 {
+				t.Errorf("Worker forwarded wrong value.\n\nExpected: %d\n\nActual: %d\n", i*11, v)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Worker did not forward value %d to the consumer.", i*11)
+		}
+	}
+
+	// The worker is now idle waiting on the ring and should stop when cancelled.
+	cancel()
+	wg.Wait()
+	if st.Forwarded != 3 || st.BytesOut != 60 || st.BytesIn != 48 || st.WorkerConns != 0 {
+		t.Errorf("Worker stats incorrect. Received %s.", st)
+	}
+}